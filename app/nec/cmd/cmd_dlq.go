@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/stn81/nec/common/dlq"
+	"github.com/stn81/nec/common/partitioner"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+	"github.com/stn81/kate/app"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var DlqFlags = &dlqFlags{}
+
+type dlqFlags struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Count     int
+	Reason    string
+	Sink      string
+}
+
+func NewDlqCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "dead-letter topic tool",
+	}
+
+	cmd.AddCommand(
+		NewDlqListCmd(),
+		NewDlqInspectCmd(),
+		NewDlqRedriveCmd(),
+	)
+
+	cmd.PersistentFlags().StringVarP(&DlqFlags.Topic, "topic", "t", "", "dead-letter topic, default to consumer.dead_letter_topic")
+	cmd.PersistentFlags().Int32VarP(&DlqFlags.Partition, "partition", "p", -1, "kafka partition, -1 for all")
+	cmd.PersistentFlags().Int64VarP(&DlqFlags.Offset, "offset", "o", sarama.OffsetOldest, "kafka offset to start from, -2 for the oldest")
+	cmd.PersistentFlags().IntVarP(&DlqFlags.Count, "count", "n", 100, "max messages per partition, 0 for no limit")
+	cmd.PersistentFlags().StringVarP(&DlqFlags.Sink, "sink", "s", "", "only the messages of the sink")
+	cmd.PersistentFlags().StringVarP(&DlqFlags.Reason, "reason", "r", "", "only the messages of the reason: decode/invalid/retries_exhausted")
+	return cmd
+}
+
+// initDlqClient loads the config, and creates the kafka client for the dead-letter topic with
+// conf, the partitioner of which is set for the redrive as the proxy
+func initDlqClient(conf *sarama.Config) (client sarama.Client, topic string, logger *zap.Logger) {
+	os.Chdir(app.GetHomeDir())
+
+	logger, err := initStdLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create std logger failed: %v", err)
+		os.Exit(1)
+	}
+
+	if err = config.Load(GlobalFlags.ConfigFile); err != nil {
+		logger.Fatal("load config failed", zap.String("file", GlobalFlags.ConfigFile), zap.Error(err))
+	}
+
+	topic = DlqFlags.Topic
+	if topic == "" {
+		topic = config.Consumer.DeadLetterTopic
+	}
+	if topic == "" {
+		logger.Fatal("no dead-letter topic specified")
+	}
+
+	conf.Version = config.Kafka.Version
+	conf.ClientID = config.Kafka.ClientID
+
+	// route the redriven messages as the proxy does
+	if config.Proxy.Partitioner == partitioner.ModeSlot {
+		conf.Producer.Partitioner = partitioner.NewSlotPartitioner
+	}
+
+	if client, err = sarama.NewClient(config.Kafka.BrokerAddrs, conf); err != nil {
+		logger.Fatal("failed to create sarama client", zap.Error(err))
+	}
+	return client, topic, logger
+}
+
+// scanDlq calls fn with the dead-letter messages matching the flags, from the offset to the
+// newest one when scanning started, fn returns false to stop.
+func scanDlq(client sarama.Client, topic string, logger *zap.Logger, fn func(msg *sarama.ConsumerMessage, meta *dlq.Meta) bool) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		logger.Fatal("failed to get partition list", zap.String("topic", topic), zap.Error(err))
+	}
+	if DlqFlags.Partition != -1 {
+		partitions = []int32{DlqFlags.Partition}
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		logger.Fatal("failed to create consumer", zap.Error(err))
+	}
+	defer consumer.Close()
+
+	for _, partition := range partitions {
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			logger.Fatal("failed to get newest offset",
+				zap.String("topic", topic),
+				zap.Int32("partition", partition),
+				zap.Error(err),
+			)
+		}
+
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			logger.Fatal("failed to get oldest offset",
+				zap.String("topic", topic),
+				zap.Int32("partition", partition),
+				zap.Error(err),
+			)
+		}
+
+		offset := DlqFlags.Offset
+		if offset < oldest {
+			offset = oldest
+		}
+		if offset >= newest {
+			continue
+		}
+
+		if !scanDlqPartition(consumer, topic, partition, offset, newest, logger, fn) {
+			return
+		}
+	}
+}
+
+func scanDlqPartition(consumer sarama.Consumer, topic string, partition int32, offset, newest int64, logger *zap.Logger,
+	fn func(msg *sarama.ConsumerMessage, meta *dlq.Meta) bool) bool {
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		logger.Fatal("failed to consume partition",
+			zap.String("topic", topic),
+			zap.Int32("partition", partition),
+			zap.Int64("offset", offset),
+			zap.Error(err),
+		)
+	}
+	defer pc.Close()
+
+	matched, stopped := 0, false
+	consumeUntil(pc, newest, func(msg *sarama.ConsumerMessage) bool {
+		meta := dlq.Parse(msg.Headers)
+		if (DlqFlags.Reason == "" || DlqFlags.Reason == meta.Reason) && (DlqFlags.Sink == "" || DlqFlags.Sink == meta.Sink) {
+			if !fn(msg, meta) {
+				stopped = true
+				return false
+			}
+			matched++
+		}
+		return DlqFlags.Count <= 0 || matched < DlqFlags.Count
+	})
+	return !stopped
+}
+
+// printDlqMessage prints the dead-letter message, with the request in it if verbose
+func printDlqMessage(msg *sarama.ConsumerMessage, meta *dlq.Meta, verbose bool) {
+	if !verbose {
+		fmt.Printf("%s/%v/%v sink=%s reason=%s attempts=%v origin=%s/%v/%v key=%s error=%q\n",
+			msg.Topic, msg.Partition, msg.Offset,
+			meta.Sink, meta.Reason, meta.Attempts,
+			meta.Topic, meta.Partition, meta.Offset,
+			msg.Key, meta.Error,
+		)
+		return
+	}
+
+	fmt.Printf("===========%s/%v/%v===========\n", msg.Topic, msg.Partition, msg.Offset)
+	fmt.Printf("timestamp: %v\n", msg.Timestamp.Format(time.RFC3339Nano))
+	fmt.Printf("key: %s\n", msg.Key)
+	fmt.Printf("sink: %s\n", meta.Sink)
+	fmt.Printf("reason: %s\n", meta.Reason)
+	fmt.Printf("attempts: %v\n", meta.Attempts)
+	fmt.Printf("origin: %s/%v/%v\n", meta.Topic, meta.Partition, meta.Offset)
+	fmt.Printf("error: %s\n", meta.Error)
+	fmt.Printf("failed_at: %v\n", meta.Time.Format(time.RFC3339Nano))
+
+	req := &proxy.Request{}
+	if err := proto.Unmarshal(msg.Value, req); err != nil {
+		fmt.Printf("value: <undecodable: %v> %q\n", err, msg.Value)
+		return
+	}
+
+	fmt.Println("value:")
+	if len(req.Multi) > 0 {
+		for _, sub := range req.Multi {
+			fmt.Println(sub.Cmd, string(bytes.Join(sub.Args, []byte(" "))))
+		}
+	} else {
+		fmt.Println(req.Cmd, string(bytes.Join(req.Args, []byte(" "))))
+	}
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/stn81/nec/common/dlq"
+	"github.com/Shopify/sarama"
+	"github.com/spf13/cobra"
+)
+
+func NewDlqInspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "inspect dead-letter messages with the requests in them",
+		Run:   dlqInspectCmdFunc,
+	}
+	return cmd
+}
+
+func dlqInspectCmdFunc(cmd *cobra.Command, args []string) {
+	client, topic, logger := initDlqClient(sarama.NewConfig())
+	defer client.Close()
+
+	scanDlq(client, topic, logger, func(msg *sarama.ConsumerMessage, meta *dlq.Meta) bool {
+		printDlqMessage(msg, meta, true)
+		return true
+	})
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/stn81/nec/common/dlq"
+	"github.com/Shopify/sarama"
+	"github.com/spf13/cobra"
+)
+
+func NewDlqListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list dead-letter messages",
+		Run:   dlqListCmdFunc,
+	}
+	return cmd
+}
+
+func dlqListCmdFunc(cmd *cobra.Command, args []string) {
+	client, topic, logger := initDlqClient(sarama.NewConfig())
+	defer client.Close()
+
+	scanDlq(client, topic, logger, func(msg *sarama.ConsumerMessage, meta *dlq.Meta) bool {
+		printDlqMessage(msg, meta, false)
+		return true
+	})
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"github.com/stn81/nec/common/dlq"
+	"github.com/stn81/nec/config"
+	"github.com/Shopify/sarama"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var DlqRedriveFlags = &dlqRedriveFlags{}
+
+type dlqRedriveFlags struct {
+	DryRun bool
+}
+
+func NewDlqRedriveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "redrive",
+		Short: "produce dead-letter messages back onto the main topic",
+		Run:   dlqRedriveCmdFunc,
+	}
+	cmd.Flags().BoolVar(&DlqRedriveFlags.DryRun, "dry_run", false, "list the messages to redrive only")
+	return cmd
+}
+
+func dlqRedriveCmdFunc(cmd *cobra.Command, args []string) {
+	conf := sarama.NewConfig()
+	conf.Producer.RequiredAcks = sarama.WaitForAll
+	conf.Producer.Return.Successes = true
+
+	client, topic, logger := initDlqClient(conf)
+	defer client.Close()
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		logger.Fatal("failed to create producer", zap.Error(err))
+	}
+	defer producer.Close()
+
+	redriven := 0
+	scanDlq(client, topic, logger, func(msg *sarama.ConsumerMessage, meta *dlq.Meta) bool {
+		printDlqMessage(msg, meta, false)
+		if DlqRedriveFlags.DryRun {
+			return true
+		}
+
+		message := &sarama.ProducerMessage{
+			Topic: config.Kafka.Topic,
+			Value: sarama.ByteEncoder(msg.Value),
+		}
+		if msg.Key != nil {
+			message.Key = sarama.ByteEncoder(msg.Key)
+		}
+
+		partition, offset, err := producer.SendMessage(message)
+		if err != nil {
+			logger.Fatal("failed to redrive message",
+				zap.String("topic", msg.Topic),
+				zap.Int32("partition", msg.Partition),
+				zap.Int64("offset", msg.Offset),
+				zap.Error(err),
+			)
+		}
+
+		logger.Info("message redriven",
+			zap.String("topic", msg.Topic),
+			zap.Int32("partition", msg.Partition),
+			zap.Int64("offset", msg.Offset),
+			zap.String("to_topic", config.Kafka.Topic),
+			zap.Int32("to_partition", partition),
+			zap.Int64("to_offset", offset),
+		)
+		redriven++
+		return true
+	})
+
+	logger.Info("redrive finished", zap.Int("redriven", redriven))
+}
@@ -108,7 +108,13 @@ func fetchCmdFunc(cmd *cobra.Command, args []string) {
 	fmt.Printf("timestamp: %v\n", recordTimestamp.Format(time.RFC3339Nano))
 	fmt.Printf("key: %v\n", record.Key)
 	fmt.Println("value:")
-	fmt.Println(string(bytes.Join(req.Args, []byte(" "))))
+	if len(req.Multi) > 0 {
+		for _, sub := range req.Multi {
+			fmt.Println(sub.Cmd, string(bytes.Join(sub.Args, []byte(" "))))
+		}
+	} else {
+		fmt.Println(string(bytes.Join(req.Args, []byte(" "))))
+	}
 
 	if FetchFlags.DumpPath != "" {
 		if err := ioutil.WriteFile(FetchFlags.DumpPath, record.Value, 0666); err != nil {
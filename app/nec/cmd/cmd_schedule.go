@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/stn81/nec/common/schedule"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+	"github.com/stn81/kate/app"
+	"github.com/stn81/kate/rdb"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var ScheduleFlags = &scheduleFlags{}
+
+type scheduleFlags struct {
+	Topic string
+	Sink  string
+}
+
+func NewScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "scheduled writes tool",
+	}
+
+	cmd.AddCommand(
+		NewScheduleListCmd(),
+		NewScheduleCancelCmd(),
+	)
+
+	cmd.PersistentFlags().StringVarP(&ScheduleFlags.Topic, "topic", "t", "", "delay topic, default to consumer.delay_topic")
+	cmd.PersistentFlags().StringVarP(&ScheduleFlags.Sink, "sink", "s", "", "only the scheduled writes of the sink")
+	return cmd
+}
+
+// initSchedule loads the config, and connects redis for the cancellations
+func initSchedule() (topic string, logger *zap.Logger) {
+	os.Chdir(app.GetHomeDir())
+
+	logger, err := initStdLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create std logger failed: %v", err)
+		os.Exit(1)
+	}
+
+	if err = config.Load(GlobalFlags.ConfigFile); err != nil {
+		logger.Fatal("load config failed", zap.String("file", GlobalFlags.ConfigFile), zap.Error(err))
+	}
+
+	topic = ScheduleFlags.Topic
+	if topic == "" {
+		topic = config.Consumer.DelayTopic
+	}
+	if topic == "" {
+		logger.Fatal("no delay topic specified")
+	}
+
+	rdb.Init(config.Redis.Config)
+	return topic, logger
+}
+
+// scanSchedule calls fn with the parked messages of the sink flag, from the oldest to the
+// newest one when scanning started.
+func scanSchedule(topic string, logger *zap.Logger, fn func(msg *sarama.ConsumerMessage, meta *schedule.Meta)) {
+	conf := sarama.NewConfig()
+	conf.Version = config.Kafka.Version
+	conf.ClientID = config.Kafka.ClientID
+
+	client, err := sarama.NewClient(config.Kafka.BrokerAddrs, conf)
+	if err != nil {
+		logger.Fatal("failed to create sarama client", zap.Error(err))
+	}
+	defer client.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		logger.Fatal("failed to get partition list", zap.String("topic", topic), zap.Error(err))
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		logger.Fatal("failed to create consumer", zap.Error(err))
+	}
+	defer consumer.Close()
+
+	for _, partition := range partitions {
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			logger.Fatal("failed to get newest offset",
+				zap.String("topic", topic),
+				zap.Int32("partition", partition),
+				zap.Error(err),
+			)
+		}
+
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			logger.Fatal("failed to get oldest offset",
+				zap.String("topic", topic),
+				zap.Int32("partition", partition),
+				zap.Error(err),
+			)
+		}
+		if oldest >= newest {
+			continue
+		}
+
+		pc, err := consumer.ConsumePartition(topic, partition, oldest)
+		if err != nil {
+			logger.Fatal("failed to consume partition",
+				zap.String("topic", topic),
+				zap.Int32("partition", partition),
+				zap.Error(err),
+			)
+		}
+
+		consumeUntil(pc, newest, func(msg *sarama.ConsumerMessage) bool {
+			if meta := schedule.Parse(msg.Headers); ScheduleFlags.Sink == "" || ScheduleFlags.Sink == meta.Sink {
+				fn(msg, meta)
+			}
+			return true
+		})
+		pc.Close()
+	}
+}
+
+// printScheduled prints the parked message with its status
+func printScheduled(msg *sarama.ConsumerMessage, meta *schedule.Meta, status string) {
+	command := "<undecodable>"
+	req := &proxy.Request{}
+	if err := proto.Unmarshal(msg.Value, req); err == nil {
+		command = req.Cmd
+	}
+
+	fmt.Printf("id=%s sink=%s apply_at=%s status=%s key=%s command=%s\n",
+		meta.ID(), meta.Sink, meta.ApplyAt.Format(time.RFC3339Nano), status, msg.Key, command,
+	)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stn81/nec/common/schedule"
+	"github.com/Shopify/sarama"
+	"github.com/stn81/kate/rdb"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var ScheduleCancelFlags = &scheduleCancelFlags{}
+
+type scheduleCancelFlags struct {
+	Retention time.Duration
+}
+
+func NewScheduleCancelCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cancel <id>...",
+		Short: "cancel pending scheduled writes by id, as listed, of the sink flag or all sinks",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   scheduleCancelCmdFunc,
+	}
+	cmd.Flags().DurationVar(&ScheduleCancelFlags.Retention, "retention", 7*24*time.Hour,
+		"how long the cancellation is kept, at least the retention of the delay topic")
+	return cmd
+}
+
+func scheduleCancelCmdFunc(cmd *cobra.Command, args []string) {
+	topic, logger := initSchedule()
+
+	// the ids found, each sink has its own parked copy of the write
+	ids := make(map[string]bool, len(args))
+	for _, id := range args {
+		ids[id] = false
+	}
+
+	scanSchedule(topic, logger, func(msg *sarama.ConsumerMessage, meta *schedule.Meta) {
+		id := meta.ID()
+		if _, ok := ids[id]; !ok {
+			return
+		}
+		// kept as long as the parked message, which may be consumed again after a rebalance
+		if err := rdb.Get().Set(schedule.CancelKey(meta.Sink, id), 1, ScheduleCancelFlags.Retention).Err(); err != nil {
+			logger.Fatal("failed to cancel scheduled write", zap.String("id", id), zap.String("sink", meta.Sink), zap.Error(err))
+		}
+		ids[id] = true
+		printScheduled(msg, meta, "cancelled")
+	})
+
+	for id, found := range ids {
+		if !found {
+			fmt.Printf("id=%s not found\n", id)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/stn81/nec/common/schedule"
+	"github.com/Shopify/sarama"
+	"github.com/stn81/kate/rdb"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var ScheduleListFlags = &scheduleListFlags{}
+
+type scheduleListFlags struct {
+	All bool
+}
+
+func NewScheduleListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list pending scheduled writes",
+		Run:   scheduleListCmdFunc,
+	}
+	cmd.Flags().BoolVarP(&ScheduleListFlags.All, "all", "a", false, "list the due and cancelled ones too")
+	return cmd
+}
+
+func scheduleListCmdFunc(cmd *cobra.Command, args []string) {
+	topic, logger := initSchedule()
+	now := time.Now()
+
+	scanSchedule(topic, logger, func(msg *sarama.ConsumerMessage, meta *schedule.Meta) {
+		status := "pending"
+		if !meta.ApplyAt.After(now) {
+			status = "due"
+		}
+
+		cancelled, err := rdb.Get().Exists(schedule.CancelKey(meta.Sink, meta.ID())).Result()
+		if err != nil {
+			logger.Fatal("failed to check cancellation", zap.String("id", meta.ID()), zap.Error(err))
+		}
+		if cancelled > 0 {
+			status = "cancelled"
+		}
+
+		if ScheduleListFlags.All || status == "pending" {
+			printScheduled(msg, meta, status)
+		}
+	})
+}
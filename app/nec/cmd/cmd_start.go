@@ -20,6 +20,7 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/stn81/nec/common/route"
 	"github.com/stn81/nec/config"
 	"github.com/stn81/nec/consumer"
 	"github.com/stn81/nec/httpsrv"
@@ -77,6 +78,10 @@ func startCmdFunc(_ *cobra.Command, _ []string) {
 	rdb.Init(config.Redis.Config)
 	defer rdb.Uninit()
 
+	if err := route.Init(config.Routes); err != nil {
+		logger.Fatal("init routes failed", zap.Error(err))
+	}
+
 	// setup upgrader to support zero-downtime upgrade/restart
 	upgrader, err := tableflip.New(tableflip.Options{
 		PIDFile:        app.GetPidFile(),
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// scanIdleTimeout stops a partition scan when no message arrives in it, as the offsets
+// below the end may never be delivered: transaction markers, compacted or deleted records.
+const scanIdleTimeout = 5 * time.Second
+
+// consumeUntil calls fn with the messages of pc below the offset end, fn returns false to
+// stop. It stops at the end, the high water mark, or once idle for scanIdleTimeout.
+func consumeUntil(pc sarama.PartitionConsumer, end int64, fn func(msg *sarama.ConsumerMessage) bool) {
+	idle := time.NewTimer(scanIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok || msg.Offset >= end {
+				return
+			}
+			if !fn(msg) {
+				return
+			}
+			if next := msg.Offset + 1; next >= end || next >= pc.HighWaterMarkOffset() {
+				return
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(scanIdleTimeout)
+		case <-idle.C:
+			return
+		}
+	}
+}
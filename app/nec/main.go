@@ -29,6 +29,8 @@ func main() {
 		cmd.NewCliCmd(),
 		cmd.NewFetchCmd(),
 		cmd.NewOffsetCmd(),
+		cmd.NewDlqCmd(),
+		cmd.NewScheduleCmd(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,89 @@
+package dlq
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// the headers of the dead-letter message
+const (
+	HeaderSink      = "nec-dlq-sink"
+	HeaderReason    = "nec-dlq-reason"
+	HeaderAttempts  = "nec-dlq-attempts"
+	HeaderTopic     = "nec-dlq-topic"
+	HeaderPartition = "nec-dlq-partition"
+	HeaderOffset    = "nec-dlq-offset"
+	HeaderError     = "nec-dlq-error"
+	HeaderTime      = "nec-dlq-time"
+)
+
+// the reasons of the dead-letter message
+const (
+	ReasonDecode    = "decode"
+	ReasonInvalid   = "invalid"
+	ReasonExhausted = "retries_exhausted"
+)
+
+// Meta is the failure info of the dead-letter message
+type Meta struct {
+	Sink      string
+	Reason    string
+	Attempts  int
+	Topic     string
+	Partition int32
+	Offset    int64
+	Error     string
+	Time      time.Time
+}
+
+// Headers returns the kafka headers of meta
+func (m *Meta) Headers() []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte(HeaderSink), Value: []byte(m.Sink)},
+		{Key: []byte(HeaderReason), Value: []byte(m.Reason)},
+		{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(m.Attempts))},
+		{Key: []byte(HeaderTopic), Value: []byte(m.Topic)},
+		{Key: []byte(HeaderPartition), Value: []byte(strconv.FormatInt(int64(m.Partition), 10))},
+		{Key: []byte(HeaderOffset), Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		{Key: []byte(HeaderError), Value: []byte(m.Error)},
+		{Key: []byte(HeaderTime), Value: []byte(m.Time.Format(time.RFC3339Nano))},
+	}
+}
+
+// Parse parses the meta from the headers of a dead-letter message, unknown or malformed
+// headers are ignored.
+func Parse(headers []*sarama.RecordHeader) *Meta {
+	m := &Meta{Partition: -1, Offset: -1}
+	for _, header := range headers {
+		if header == nil {
+			continue
+		}
+
+		value := string(header.Value)
+		switch string(header.Key) {
+		case HeaderSink:
+			m.Sink = value
+		case HeaderReason:
+			m.Reason = value
+		case HeaderAttempts:
+			m.Attempts, _ = strconv.Atoi(value)
+		case HeaderTopic:
+			m.Topic = value
+		case HeaderPartition:
+			if partition, err := strconv.ParseInt(value, 10, 32); err == nil {
+				m.Partition = int32(partition)
+			}
+		case HeaderOffset:
+			if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+				m.Offset = offset
+			}
+		case HeaderError:
+			m.Error = value
+		case HeaderTime:
+			m.Time, _ = time.Parse(time.RFC3339Nano, value)
+		}
+	}
+	return m
+}
@@ -0,0 +1,71 @@
+// Package hashtag implements the key hash slot of redis cluster.
+package hashtag
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SlotNumber is the number of hash slots of redis cluster
+const SlotNumber = 16384
+
+// Key returns the part of key which is hashed to get the slot, that is the content of
+// the first non-empty {hashtag} if any, otherwise the whole key.
+func Key(key string) string {
+	if s := strings.IndexByte(key, '{'); s > -1 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+e+1]
+		}
+	}
+	return key
+}
+
+// Slot returns the hash slot of key in redis cluster
+func Slot(key string) int {
+	return int(crc16(Key(key)) % SlotNumber)
+}
+
+// Tagged returns the key of name which is in the same slot as key.
+// It is not possible if the hash part of key contains '}', and ok is false then.
+func Tagged(name, key string) (tagged string, ok bool) {
+	tag := Key(key)
+	if tag == "" || strings.IndexByte(tag, '}') > -1 {
+		return "", false
+	}
+	return name + "{" + tag + "}", true
+}
+
+var (
+	slotTagsOnce sync.Once
+	slotTags     [SlotNumber]string
+)
+
+// SlotTag returns a short hashtag of the slot, so that name+"{"+tag+"}" is in the slot
+func SlotTag(slot int) string {
+	slotTagsOnce.Do(func() {
+		for i, found := 0, 0; found < SlotNumber; i++ {
+			tag := strconv.FormatInt(int64(i), 36)
+			if slot := crc16(tag) % SlotNumber; slotTags[slot] == "" {
+				slotTags[slot] = tag
+				found++
+			}
+		}
+	})
+	return slotTags[slot]
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) used by redis cluster
+func crc16(key string) (crc uint16) {
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
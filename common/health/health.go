@@ -0,0 +1,34 @@
+package health
+
+import "sync"
+
+var (
+	mu       sync.RWMutex
+	checkers = make(map[string]func() string)
+)
+
+// Register registers the checker of the named component, which returns its status
+func Register(name string, checker func() string) {
+	mu.Lock()
+	defer mu.Unlock()
+	checkers[name] = checker
+}
+
+// Unregister removes the checker of the named component
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(checkers, name)
+}
+
+// Status returns the status of the registered components
+func Status() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	status := make(map[string]string, len(checkers))
+	for name, checker := range checkers {
+		status[name] = checker()
+	}
+	return status
+}
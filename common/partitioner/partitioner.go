@@ -0,0 +1,72 @@
+// Package partitioner maps kafka messages to partitions by the redis cluster slot of the key.
+package partitioner
+
+import (
+	"math/rand"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/common/hashtag"
+)
+
+const (
+	// ModeKey partitions by the hash of the whole key, which is the sarama default
+	ModeKey = "key"
+	// ModeSlot partitions by the redis cluster slot of the key, honoring the {hashtag}
+	ModeSlot = "slot"
+)
+
+// Partition returns the partition of the slot of key among numPartitions.
+//
+// Slots are mapped with the jump consistent hash, so when the partition count grows
+// from n to n+1, only 1/(n+1) of the slots move, and they all move to the new partition.
+func Partition(key []byte, numPartitions int32) int32 {
+	return jump(mix(uint64(hashtag.Slot(string(key)))), numPartitions)
+}
+
+type slotPartitioner struct{}
+
+// NewSlotPartitioner is the sarama.PartitionerConstructor of ModeSlot
+func NewSlotPartitioner(topic string) sarama.Partitioner {
+	return &slotPartitioner{}
+}
+
+// Partition implements the `sarama.Partitioner.Partition()` method
+func (p *slotPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return rand.Int31n(numPartitions), nil
+	}
+
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+
+	return Partition(key, numPartitions), nil
+}
+
+// RequiresConsistency implements the `sarama.Partitioner.RequiresConsistency()` method
+func (p *slotPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// jump is the jump consistent hash by Lamping and Veach
+func jump(key uint64, buckets int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+// mix spreads the small slot numbers over 64 bits (splitmix64 finalizer)
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
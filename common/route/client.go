@@ -0,0 +1,56 @@
+package route
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+)
+
+// NewClient creates the redis client of a target as rdb.Init does
+func NewClient(conf *rdb.Config) rdb.Client {
+	if !conf.ClusterEnabled {
+		return redis.NewClient(&redis.Options{
+			Addr:               conf.Addrs[0],
+			DB:                 conf.DB,
+			Password:           conf.Password,
+			MaxRetries:         conf.MaxRetries,
+			MinRetryBackoff:    conf.MinRetryBackoff,
+			MaxRetryBackoff:    conf.MaxRetryBackoff,
+			DialTimeout:        conf.ConnectTimeout,
+			ReadTimeout:        conf.ReadTimeout,
+			WriteTimeout:       conf.WriteTimeout,
+			PoolSize:           conf.PoolSize,
+			MinIdleConns:       conf.MinIdleConns,
+			MaxConnAge:         conf.MaxConnAge,
+			PoolTimeout:        conf.PoolTimeout,
+			IdleTimeout:        conf.IdleTimeout,
+			IdleCheckFrequency: conf.IdleCheckFrequency,
+		})
+	}
+
+	opt := &redis.ClusterOptions{
+		Addrs:              conf.Addrs,
+		Password:           conf.Password,
+		MaxRedirects:       conf.MaxRedirects,
+		MaxRetries:         conf.MaxRetries,
+		MinRetryBackoff:    conf.MinRetryBackoff,
+		MaxRetryBackoff:    conf.MaxRetryBackoff,
+		DialTimeout:        conf.ConnectTimeout,
+		ReadTimeout:        conf.ReadTimeout,
+		WriteTimeout:       conf.WriteTimeout,
+		PoolSize:           conf.PoolSize,
+		MinIdleConns:       conf.MinIdleConns,
+		MaxConnAge:         conf.MaxConnAge,
+		PoolTimeout:        conf.PoolTimeout,
+		IdleTimeout:        conf.IdleTimeout,
+		IdleCheckFrequency: conf.IdleCheckFrequency,
+	}
+
+	switch conf.RouteMode {
+	case rdb.RouteModeMasterSlaveRandom:
+		opt.RouteRandomly = true
+	case rdb.RouteModeMasterSlaveLatency:
+		opt.RouteByLatency = true
+	}
+
+	return redis.NewClusterClient(opt)
+}
@@ -0,0 +1,144 @@
+// Package route routes the keys to the redis targets by the rules in config.
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// the kinds of rule
+const (
+	KindPrefix = "prefix"
+	KindGlob   = "glob"
+	KindRegex  = "regex"
+)
+
+// Rule routes the keys matching the pattern to the db of the target. The target is the
+// name of a redis sink, and db -1 means the db configured for it.
+type Rule struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern"`
+	Target  string `json:"target"`
+	DB      int    `json:"db"`
+	re      *regexp.Regexp
+}
+
+// Match returns whether the key matches the rule
+func (r *Rule) Match(key string) bool {
+	if r.Kind == KindPrefix {
+		return strings.HasPrefix(key, r.Pattern)
+	}
+	return r.re.MatchString(key)
+}
+
+// Same returns whether the rules route to the same db of the same target
+func (r *Rule) Same(other *Rule) bool {
+	return r.Target == other.Target && r.DB == other.DB
+}
+
+// Table is the ordered routing rules, the first matched rule routes the key
+type Table struct {
+	rules []*Rule
+}
+
+// NewTable creates the routing table of the rules
+func NewTable(rules []*Rule) (*Table, error) {
+	t := &Table{}
+	for _, rule := range rules {
+		r := *rule
+		switch r.Kind {
+		case KindPrefix:
+		case KindGlob:
+			re, err := regexp.Compile(globToRegex(r.Pattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob of route %v: %w", r.Name, err)
+			}
+			r.re = re
+		case KindRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex of route %v: %w", r.Name, err)
+			}
+			r.re = re
+		default:
+			return nil, fmt.Errorf("invalid kind of route %v: %q", r.Name, r.Kind)
+		}
+		t.rules = append(t.rules, &r)
+	}
+	return t, nil
+}
+
+// Enabled returns whether any rule is configured, all keys go to the default target if not
+func (t *Table) Enabled() bool {
+	return len(t.rules) > 0
+}
+
+// Match returns the first rule matching the key, nil if none
+func (t *Table) Match(key string) *Rule {
+	for _, rule := range t.rules {
+		if rule.Match(key) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Rules returns the rules in order
+func (t *Table) Rules() []*Rule {
+	return t.rules
+}
+
+// globToRegex converts the redis style glob, with *, ? and [...], to an anchored regex
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			if j := strings.IndexByte(glob[i+1:], ']'); j > 0 {
+				class := glob[i+1 : i+1+j]
+				if class[0] == '^' {
+					class = "^" + regexp.QuoteMeta(class[1:])
+				} else {
+					class = regexp.QuoteMeta(class)
+				}
+				b.WriteString("[" + class + "]")
+				i += j + 1
+			} else {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+var table = &Table{}
+
+// Init initializes the global routing table
+func Init(rules []*Rule) error {
+	t, err := NewTable(rules)
+	if err != nil {
+		return err
+	}
+	table = t
+	return nil
+}
+
+// Get returns the global routing table
+func Get() *Table {
+	return table
+}
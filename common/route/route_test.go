@@ -0,0 +1,57 @@
+package route
+
+import (
+	"testing"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob  string
+		regex string
+	}{
+		{glob: "user:*", regex: `^user:.*$`},
+		{glob: "a?c", regex: `^a.c$`},
+		{glob: "[abc]x", regex: `^[abc]x$`},
+		{glob: "[^ab]x", regex: `^[^ab]x$`},
+		{glob: "[a-z]*", regex: `^[a-z].*$`},
+		{glob: `\*x`, regex: `^\*x$`},
+		{glob: "a[b", regex: `^a\[b$`},
+		{glob: "[]x", regex: `^\[\]x$`},
+		{glob: "a.b+", regex: `^a\.b\+$`},
+		{glob: `x\`, regex: `^x\\$`},
+	}
+
+	for _, tt := range tests {
+		if got := globToRegex(tt.glob); got != tt.regex {
+			t.Errorf("globToRegex(%q) = %q, want %q", tt.glob, got, tt.regex)
+		}
+	}
+}
+
+func TestTableGlob(t *testing.T) {
+	table, err := NewTable([]*Rule{
+		{Name: "user", Kind: KindGlob, Pattern: "user:[0-9]*"},
+		{Name: "item", Kind: KindGlob, Pattern: "item:?"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{"user:1": "user", "user:x": "", "item:a": "item", "item:ab": "", "xuser:1": ""} {
+		var got string
+		if rule := table.Match(key); rule != nil {
+			got = rule.Name
+		}
+		if got != want {
+			t.Errorf("Match(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestTableInvalidGlob(t *testing.T) {
+	for _, glob := range []string{"[z-a]*", "[^]x]"} {
+		if _, err := NewTable([]*Rule{{Name: "bad", Kind: KindGlob, Pattern: glob}}); err == nil {
+			t.Errorf("NewTable(%q) succeeded, want error", glob)
+		}
+	}
+}
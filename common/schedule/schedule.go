@@ -0,0 +1,83 @@
+// Package schedule implements the meta of the scheduled writes parked in the delay topic.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// the headers of the parked message
+const (
+	HeaderSink      = "nec-sched-sink"
+	HeaderApplyAt   = "nec-sched-apply-at"
+	HeaderTopic     = "nec-sched-topic"
+	HeaderPartition = "nec-sched-partition"
+	HeaderOffset    = "nec-sched-offset"
+)
+
+const cancelKeyPrefix = "nec:sched:cancel:"
+
+// Meta is the schedule info of the parked message
+type Meta struct {
+	Sink      string
+	ApplyAt   time.Time
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ID returns the id of the scheduled write, the origin of the message
+func (m *Meta) ID() string {
+	return fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)
+}
+
+// Headers returns the kafka headers of meta
+func (m *Meta) Headers() []sarama.RecordHeader {
+	return []sarama.RecordHeader{
+		{Key: []byte(HeaderSink), Value: []byte(m.Sink)},
+		{Key: []byte(HeaderApplyAt), Value: []byte(strconv.FormatInt(m.ApplyAt.UnixNano()/int64(time.Millisecond), 10))},
+		{Key: []byte(HeaderTopic), Value: []byte(m.Topic)},
+		{Key: []byte(HeaderPartition), Value: []byte(strconv.FormatInt(int64(m.Partition), 10))},
+		{Key: []byte(HeaderOffset), Value: []byte(strconv.FormatInt(m.Offset, 10))},
+	}
+}
+
+// Parse parses the meta from the headers of a parked message, unknown or malformed
+// headers are ignored.
+func Parse(headers []*sarama.RecordHeader) *Meta {
+	m := &Meta{Partition: -1, Offset: -1}
+	for _, header := range headers {
+		if header == nil {
+			continue
+		}
+
+		value := string(header.Value)
+		switch string(header.Key) {
+		case HeaderSink:
+			m.Sink = value
+		case HeaderApplyAt:
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+				m.ApplyAt = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		case HeaderTopic:
+			m.Topic = value
+		case HeaderPartition:
+			if partition, err := strconv.ParseInt(value, 10, 32); err == nil {
+				m.Partition = int32(partition)
+			}
+		case HeaderOffset:
+			if offset, err := strconv.ParseInt(value, 10, 64); err == nil {
+				m.Offset = offset
+			}
+		}
+	}
+	return m
+}
+
+// CancelKey returns the redis key marking the scheduled write of id as cancelled for the sink
+func CancelKey(sink, id string) string {
+	return cancelKeyPrefix + sink + ":" + id
+}
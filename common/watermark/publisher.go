@@ -0,0 +1,114 @@
+package watermark
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+	"go.uber.org/zap"
+)
+
+// setMaxScript only moves the applied offsets forward, so a consumer which just lost the
+// partition in a rebalance can not move it backward.
+var setMaxScript = redis.NewScript(`
+for i = 1, #ARGV, 2 do
+	local cur = tonumber(redis.call('HGET', KEYS[1], ARGV[i]) or '-1')
+	if tonumber(ARGV[i+1]) > cur then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i+1])
+	end
+end
+return 1
+`)
+
+// Publisher publishes the applied offsets of the consumer to redis periodically
+type Publisher struct {
+	client   rdb.Client
+	key      string
+	interval time.Duration
+	logger   *zap.Logger
+	mu       sync.Mutex
+	pending  map[int32]int64
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewPublisher create a publisher for the consumer group on topic
+func NewPublisher(client rdb.Client, group, topic string, interval time.Duration, logger *zap.Logger) *Publisher {
+	return &Publisher{
+		client:   client,
+		key:      Key(group, topic),
+		interval: interval,
+		logger:   logger,
+		pending:  make(map[int32]int64),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts the background publishing
+func (p *Publisher) Start() {
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Stop stops the background publishing, and flushes the pending offsets
+func (p *Publisher) Stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.flush()
+}
+
+// Mark records the offset of partition as applied
+func (p *Publisher) Mark(partition int32, offset int64) {
+	p.mu.Lock()
+	if cur, ok := p.pending[partition]; !ok || offset > cur {
+		p.pending[partition] = offset
+	}
+	p.mu.Unlock()
+}
+
+func (p *Publisher) loop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Publisher) flush() {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[int32]int64, len(pending))
+	p.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	args := make([]interface{}, 0, len(pending)*2)
+	for partition, offset := range pending {
+		args = append(args, strconv.Itoa(int(partition)), offset)
+	}
+
+	if err := setMaxScript.Run(p.client, []string{p.key}, args...).Err(); err != nil {
+		p.logger.Error("failed to publish applied offsets",
+			zap.String("key", p.key),
+			zap.Any("offsets", pending),
+			zap.Error(err),
+		)
+
+		// merge back to retry in the next round
+		for partition, offset := range pending {
+			p.Mark(partition, offset)
+		}
+	}
+}
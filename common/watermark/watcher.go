@@ -0,0 +1,183 @@
+package watermark
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+	"go.uber.org/zap"
+)
+
+// Watcher watches the applied offsets published by the consumer group, and wakes up the
+// waiters when their offset is applied. Redis is only polled while there are waiters.
+type Watcher struct {
+	client   rdb.Client
+	key      string
+	interval time.Duration
+	logger   *zap.Logger
+	mu       sync.Mutex
+	applied  map[int32]int64
+	waiters  map[*waiter]struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type waiter struct {
+	partition int32
+	offset    int64
+	ch        chan struct{}
+}
+
+// NewWatcher create a watcher for the consumer group on topic
+func NewWatcher(client rdb.Client, group, topic string, interval time.Duration, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		client:   client,
+		key:      Key(group, topic),
+		interval: interval,
+		logger:   logger,
+		applied:  make(map[int32]int64),
+		waiters:  make(map[*waiter]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts the background polling
+func (w *Watcher) Start() {
+	w.wg.Add(1)
+	go w.loop()
+}
+
+// Stop stops the background polling
+func (w *Watcher) Stop() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+// Wait blocks until the offset of partition is applied, or the ctx is done.
+// The last known applied offset of partition is returned.
+func (w *Watcher) Wait(ctx context.Context, partition int32, offset int64) (applied int64, err error) {
+	w.mu.Lock()
+	if applied, ok := w.applied[partition]; ok && applied >= offset {
+		w.mu.Unlock()
+		return applied, nil
+	}
+
+	wt := &waiter{
+		partition: partition,
+		offset:    offset,
+		ch:        make(chan struct{}),
+	}
+	w.waiters[wt] = struct{}{}
+	w.mu.Unlock()
+
+	select {
+	case <-wt.ch:
+		return w.Applied(partition), nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		delete(w.waiters, wt)
+		w.mu.Unlock()
+		return w.Applied(partition), ctx.Err()
+	}
+}
+
+// Applied returns the last known applied offset of partition, -1 if unknown.
+func (w *Watcher) Applied(partition int32) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if applied, ok := w.applied[partition]; ok {
+		return applied
+	}
+	return -1
+}
+
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.poll()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	w.mu.Lock()
+	idle := len(w.waiters) == 0
+	w.mu.Unlock()
+
+	if idle {
+		return
+	}
+
+	if err := w.Refresh(); err != nil {
+		w.logger.Error("failed to poll applied offsets", zap.String("key", w.key), zap.Error(err))
+	}
+}
+
+// Refresh reloads the applied offsets of all partitions from redis
+func (w *Watcher) Refresh() error {
+	values, err := w.client.HGetAll(w.key).Result()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for field, value := range values {
+		partition, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			continue
+		}
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		w.update(int32(partition), offset)
+	}
+
+	return nil
+}
+
+// Fetch reloads the applied offset of partition from redis, -1 if unknown.
+func (w *Watcher) Fetch(partition int32) (int64, error) {
+	offset, err := w.client.HGet(w.key, strconv.Itoa(int(partition))).Int64()
+	switch {
+	case err == redis.Nil:
+		return w.Applied(partition), nil
+	case err != nil:
+		return -1, err
+	}
+
+	w.mu.Lock()
+	w.update(partition, offset)
+	w.mu.Unlock()
+
+	return w.Applied(partition), nil
+}
+
+// update must be called with w.mu held
+func (w *Watcher) update(partition int32, offset int64) {
+	if cur, ok := w.applied[partition]; ok && cur >= offset {
+		return
+	}
+	w.applied[partition] = offset
+
+	for wt := range w.waiters {
+		if wt.partition == partition && wt.offset <= offset {
+			close(wt.ch)
+			delete(w.waiters, wt)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package watermark shares the applied offsets of the consumer group through redis,
+// so that the proxy can tell whether a produced message has been applied.
+package watermark
+
+import "fmt"
+
+// Key returns the redis hash holding the applied offsets of the consumer group on topic.
+// The hash field is the partition, and the value is the last applied offset of it.
+func Key(group, topic string) string {
+	return fmt.Sprintf("nec:applied:%s:%s", group, topic)
+}
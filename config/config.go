@@ -44,5 +44,13 @@ func Load(file string) error {
 		}
 	}
 
+	if err = loadSinks(iniFile); err != nil {
+		return fmt.Errorf("load config: %v", err)
+	}
+
+	if err = loadRoutes(iniFile); err != nil {
+		return fmt.Errorf("load config: %v", err)
+	}
+
 	return nil
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -10,12 +12,38 @@ import (
 var Consumer = &ConsumerConfig{}
 
 type ConsumerConfig struct {
-	ConsumerGroup   string
-	BalanceStrategy sarama.BalanceStrategy
-	TPSLimit        int64
-	MaxRetries      int
-	LogFile         string
-	LogSampler      LogSamplerConfig
+	ConsumerGroup         string
+	BalanceStrategy       sarama.BalanceStrategy
+	TPSLimit              int64
+	MaxRetries            int
+	RetryInitial          time.Duration
+	RetryMaxDelay         time.Duration
+	RetryMaxElapsed       time.Duration
+	TransientErrors       []string
+	PermanentErrors       []string
+	BreakerEnabled        bool
+	BreakerWindow         time.Duration
+	BreakerMinRequests    int
+	BreakerErrorRate      float64
+	BreakerLatency        time.Duration
+	BreakerMemoryRatio    float64
+	BreakerMaxUsedMemory  int64
+	BreakerProbeInterval  time.Duration
+	BreakerProbeSuccesses int
+	Lanes                 int
+	BatchSize             int
+	BatchLinger           time.Duration
+	DeadLetterTopic       string
+	DelayTopic            string
+	MaxScheduleAhead      time.Duration
+	MaxAges               map[string]time.Duration
+	CheckpointEnabled     bool
+	VersionEnabled        bool
+	VersionRetention      time.Duration
+	AppliedPublish        time.Duration
+	IdemRetention         time.Duration
+	LogFile               string
+	LogSampler            LogSamplerConfig
 }
 
 func (conf *ConsumerConfig) SectionName() string {
@@ -24,6 +52,50 @@ func (conf *ConsumerConfig) SectionName() string {
 
 func (conf *ConsumerConfig) Load(section *ini.Section) error {
 	conf.MaxRetries = section.Key("max_retries").MustInt(10)
+	conf.RetryInitial = section.Key("retry_initial_delay").MustDuration(20 * time.Millisecond)
+	conf.RetryMaxDelay = section.Key("retry_max_delay").MustDuration(500 * time.Millisecond)
+	conf.RetryMaxElapsed = section.Key("retry_max_elapsed").MustDuration(0)
+	transientErrors := section.Key("transient_errors").MustString(
+		"LOADING,READONLY,CLUSTERDOWN,TRYAGAIN,MASTERDOWN,BUSY,MOVED,ASK,NOREPLICAS,OOM,ERR max number of clients reached")
+	conf.TransientErrors = splitList(transientErrors)
+	permanentErrors := section.Key("permanent_errors").MustString("ERR,WRONGTYPE,EXECABORT,NOPERM")
+	conf.PermanentErrors = splitList(permanentErrors)
+	conf.BreakerEnabled = section.Key("breaker_enabled").MustBool(true)
+	conf.BreakerWindow = section.Key("breaker_window").MustDuration(10 * time.Second)
+	conf.BreakerMinRequests = section.Key("breaker_min_requests").MustInt(20)
+	conf.BreakerErrorRate = section.Key("breaker_error_rate").MustFloat64(0.5)
+	conf.BreakerLatency = section.Key("breaker_latency").MustDuration(0)
+	conf.BreakerMemoryRatio = section.Key("breaker_memory_ratio").MustFloat64(0.95)
+	conf.BreakerMaxUsedMemory = section.Key("breaker_max_used_memory").MustInt64(0)
+	conf.BreakerProbeInterval = section.Key("breaker_probe_interval").MustDuration(time.Second)
+	conf.BreakerProbeSuccesses = section.Key("breaker_probe_successes").MustInt(3)
+	conf.Lanes = section.Key("lanes").MustInt(8)
+	conf.BatchSize = section.Key("batch_size").MustInt(64)
+	conf.BatchLinger = section.Key("batch_linger").MustDuration(0)
+	conf.CheckpointEnabled = section.Key("checkpoint_enabled").MustBool(false)
+	conf.VersionEnabled = section.Key("version_check_enabled").MustBool(false)
+	conf.VersionRetention = section.Key("version_retention").MustDuration(24 * time.Hour)
+	// in seconds by the script, 0 for never expired
+	if conf.VersionRetention < time.Second {
+		return fmt.Errorf("invalid version_retention: %v", conf.VersionRetention)
+	}
+	conf.DeadLetterTopic = section.Key("dead_letter_topic").MustString("")
+	conf.DelayTopic = section.Key("delay_topic").MustString("")
+	conf.MaxScheduleAhead = section.Key("max_schedule_ahead").MustDuration(72 * time.Hour)
+	if conf.MaxScheduleAhead <= 0 {
+		return fmt.Errorf("invalid max_schedule_ahead: %v", conf.MaxScheduleAhead)
+	}
+	maxAges, err := parseMaxAges(section.Key("max_age").MustString(""))
+	if err != nil {
+		return err
+	}
+	conf.MaxAges = maxAges
+	conf.AppliedPublish = section.Key("applied_publish").MustDuration(50 * time.Millisecond)
+	conf.IdemRetention = section.Key("idempotency_retention").MustDuration(24 * time.Hour)
+	// in seconds by the script, 0 for no idempotency
+	if conf.IdemRetention < time.Second {
+		return fmt.Errorf("invalid idempotency_retention: %v", conf.IdemRetention)
+	}
 	conf.TPSLimit = section.Key("tps_limit").MustInt64(100000)
 	conf.ConsumerGroup = section.Key("consumer_group").MustString("")
 	conf.LogFile = section.Key("log_file").MustString("kafka.log")
@@ -43,3 +115,32 @@ func (conf *ConsumerConfig) Load(section *ini.Section) error {
 	}
 	return nil
 }
+
+// parseMaxAges parses the comma separated "<command>:<duration>" list to the max age by
+// lower case command, "*" is for the unlisted commands.
+func parseMaxAges(list string) (map[string]time.Duration, error) {
+	maxAges := make(map[string]time.Duration)
+	for _, item := range splitList(list) {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid max_age: %v", item)
+		}
+		age, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("invalid max_age of %v: %v", kv[0], kv[1])
+		}
+		maxAges[strings.ToLower(strings.TrimSpace(kv[0]))] = age
+	}
+	return maxAges, nil
+}
+
+// splitList splits the comma separated list, the empty items are dropped
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,21 +1,57 @@
 package config
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"gopkg.in/ini.v1"
 )
 
 var Proxy = &ProxyConfig{}
 
 type ProxyConfig struct {
-	Addr       string
-	TPSLimit   int64
-	MaxRetries int
-	LogFile    string
-	LogSampler LogSamplerConfig
-	Commands   map[string]bool
+	Addr            string
+	RESPAddr        string
+	RESPReply       string
+	RESPReadThrough bool
+	TPSLimit        int64
+	MaxRetries      int
+	MaxBatchSize    int
+	MaxInflight     int
+	WaitAppliedSink string
+	WaitAppliedPoll time.Duration
+	MaxWaitApplied  time.Duration
+	PendingGC       time.Duration
+	CoalesceWindow  time.Duration
+	CoalesceCmds    map[string]bool
+	AggregateWindow time.Duration
+	AggregateCmds   map[string]bool
+	Spool           SpoolConfig
+	Producer        ProducerConfig
+	Partitioner     string
+	LogFile         string
+	LogSampler      LogSamplerConfig
+	Commands        map[string]bool
+}
+
+// ProducerConfig is the batching of the kafka producer of proxy
+type ProducerConfig struct {
+	Linger      time.Duration
+	BatchSize   int
+	Compression sarama.CompressionCodec
+	MaxInflight int
+}
+
+// SpoolConfig is the local disk spool of the writes while kafka is unavailable
+type SpoolConfig struct {
+	Enabled       bool
+	Dir           string
+	MaxBytes      int64
+	SegmentBytes  int64
+	RetryInterval time.Duration
+	Fsync         bool
 }
 
 func (conf *ProxyConfig) SectionName() string {
@@ -32,8 +68,90 @@ func (conf *ProxyConfig) Load(section *ini.Section) error {
 	}
 
 	conf.Addr = section.Key("addr").MustString(":9090")
+	conf.RESPAddr = section.Key("resp_addr").MustString("")
+	conf.RESPReadThrough = section.Key("resp_read_through").MustBool(false)
+
+	conf.RESPReply = section.Key("resp_reply").MustString("ok")
+	switch conf.RESPReply {
+	case "ok", "queued":
+	default:
+		return fmt.Errorf("invalid resp_reply: %v", conf.RESPReply)
+	}
+
 	conf.TPSLimit = section.Key("tps_limit").MustInt64(500000)
 	conf.MaxRetries = section.Key("max_retries").MustInt(3)
+	conf.MaxBatchSize = section.Key("max_batch_size").MustInt(1000)
+	conf.MaxInflight = section.Key("max_inflight").MustInt(1024)
+	conf.WaitAppliedSink = section.Key("wait_applied_sink").MustString("")
+	conf.WaitAppliedPoll = section.Key("wait_applied_poll").MustDuration(20 * time.Millisecond)
+	conf.MaxWaitApplied = section.Key("max_wait_applied").MustDuration(10 * time.Second)
+	conf.PendingGC = section.Key("pending_gc").MustDuration(time.Second)
+	conf.CoalesceWindow = section.Key("coalesce_window").MustDuration(0)
+
+	conf.CoalesceCmds = make(map[string]bool)
+	for _, cmd := range splitList(section.Key("coalesce_commands").MustString("set,setex,hset")) {
+		cmd = strings.ToLower(cmd)
+		switch cmd {
+		case "set", "setex", "psetex", "hset", "hmset":
+			conf.CoalesceCmds[cmd] = true
+		default:
+			return fmt.Errorf("command not coalescable: %v", cmd)
+		}
+	}
+
+	conf.AggregateWindow = section.Key("aggregate_window").MustDuration(0)
+
+	conf.AggregateCmds = make(map[string]bool)
+	for _, cmd := range splitList(section.Key("aggregate_commands").MustString("incrby,hincrby,zincrby")) {
+		cmd = strings.ToLower(cmd)
+		switch cmd {
+		case "incrby", "hincrby", "zincrby":
+			conf.AggregateCmds[cmd] = true
+		default:
+			return fmt.Errorf("command not aggregatable: %v", cmd)
+		}
+	}
+
+	conf.Producer.Linger = section.Key("producer_linger").MustDuration(0)
+	conf.Producer.BatchSize = section.Key("producer_batch_size").MustInt(0)
+	conf.Producer.MaxInflight = section.Key("producer_max_inflight").MustInt(5)
+	if conf.Producer.BatchSize < 0 || conf.Producer.MaxInflight <= 0 {
+		return fmt.Errorf("invalid producer batch: batch_size=%v max_inflight=%v", conf.Producer.BatchSize, conf.Producer.MaxInflight)
+	}
+
+	compression := section.Key("producer_compression").MustString("none")
+	switch strings.ToLower(compression) {
+	case "none":
+		conf.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		conf.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		conf.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		conf.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		conf.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return fmt.Errorf("invalid producer_compression: %v", compression)
+	}
+
+	conf.Spool.Enabled = section.Key("spool_enabled").MustBool(false)
+	conf.Spool.Dir = section.Key("spool_dir").MustString("")
+	conf.Spool.MaxBytes = section.Key("spool_max_bytes").MustInt64(1073741824)
+	conf.Spool.SegmentBytes = section.Key("spool_segment_bytes").MustInt64(67108864)
+	conf.Spool.RetryInterval = section.Key("spool_retry_interval").MustDuration(time.Second)
+	conf.Spool.Fsync = section.Key("spool_fsync").MustBool(true)
+	if conf.Spool.SegmentBytes <= 0 || conf.Spool.MaxBytes < conf.Spool.SegmentBytes {
+		return fmt.Errorf("invalid spool size: max_bytes=%v segment_bytes=%v", conf.Spool.MaxBytes, conf.Spool.SegmentBytes)
+	}
+
+	conf.Partitioner = section.Key("partitioner").MustString("key")
+	switch conf.Partitioner {
+	case "key", "slot":
+	default:
+		return fmt.Errorf("invalid partitioner: %v", conf.Partitioner)
+	}
+
 	conf.LogFile = section.Key("log_file").MustString("grpc.log")
 	conf.LogSampler.Enabled = section.Key("log_sampler_enabled").MustBool(false)
 	conf.LogSampler.Tick = section.Key("log_sampler_tick").MustDuration(time.Second)
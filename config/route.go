@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stn81/nec/common/route"
+)
+
+const routeSectionPrefix = "route."
+
+// Routes are the rules routing the keys to the redis targets, in the order of the
+// [route.<name>] sections. Each has one of the patterns prefix, glob or regex, the target
+// sink, "default" by default, and the db, -1 for the db of the target. The target of a
+// route must be the default sink or a disabled one, which is applied to by the default sink.
+var Routes []*route.Rule
+
+// loadRoutes loads the routes, after the sinks loaded
+func loadRoutes(iniFile *ini.File) error {
+	Routes = nil
+
+	for _, section := range iniFile.Sections() {
+		if !strings.HasPrefix(section.Name(), routeSectionPrefix) {
+			continue
+		}
+
+		rule := &route.Rule{
+			Name:   strings.TrimPrefix(section.Name(), routeSectionPrefix),
+			Target: section.Key("target").MustString(DefaultSink),
+			DB:     section.Key("db").MustInt(-1),
+		}
+
+		for _, kind := range []string{route.KindPrefix, route.KindGlob, route.KindRegex} {
+			if !section.HasKey(kind) {
+				continue
+			}
+			if rule.Kind != "" {
+				return fmt.Errorf("more than one pattern of route: %v", rule.Name)
+			}
+			rule.Kind = kind
+			rule.Pattern = section.Key(kind).String()
+		}
+		if rule.Kind == "" {
+			return fmt.Errorf("no pattern of route: %v", rule.Name)
+		}
+
+		var target *SinkConfig
+		for _, sink := range Sinks {
+			if sink.Name == rule.Target {
+				target = sink
+			}
+		}
+
+		switch {
+		case target == nil:
+			return fmt.Errorf("unknown target of route %v: %v", rule.Name, rule.Target)
+		case target.Name != DefaultSink && target.Enabled:
+			return fmt.Errorf("target of route %v is an enabled sink: %v", rule.Name, rule.Target)
+		case target.Redis.ClusterEnabled && rule.DB > 0:
+			return fmt.Errorf("db of route %v on redis cluster: %v", rule.Name, rule.DB)
+		}
+
+		Routes = append(Routes, rule)
+	}
+
+	return nil
+}
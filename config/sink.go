@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stn81/kate/rdb"
+	"gopkg.in/ini.v1"
+)
+
+// DefaultSink is the name of the sink of the [redis] section
+const DefaultSink = "default"
+
+const sinkSectionPrefix = "redis."
+
+// Sinks are the redis targets the consumer applies the requests to, each with its own
+// consumer group. The default sink is the [redis] section, and the others are the
+// [redis.<name>] sections, which inherit the unset keys from [redis] except addrs,
+// sink_enabled and consumer_group.
+var Sinks []*SinkConfig
+
+// SinkConfig defines the config of a sink
+type SinkConfig struct {
+	Name          string
+	Enabled       bool
+	ConsumerGroup string
+	Redis         *RedisConfig
+}
+
+// WaitAppliedSink returns the sink whose applied offsets WaitApplied waits for, the one named
+// by wait_applied_sink, or the first enabled one. It must be enabled, or nothing is applied.
+func WaitAppliedSink() (*SinkConfig, error) {
+	for _, sink := range Sinks {
+		switch {
+		case Proxy.WaitAppliedSink == "" && sink.Enabled:
+			return sink, nil
+		case Proxy.WaitAppliedSink == sink.Name && !sink.Enabled:
+			return nil, fmt.Errorf("wait_applied_sink is disabled: %v", sink.Name)
+		case Proxy.WaitAppliedSink == sink.Name:
+			return sink, nil
+		}
+	}
+
+	if Proxy.WaitAppliedSink == "" {
+		return nil, fmt.Errorf("no enabled sink for wait_applied_sink")
+	}
+	return nil, fmt.Errorf("unknown wait_applied_sink: %v", Proxy.WaitAppliedSink)
+}
+
+// loadSinks loads the sinks, after the [redis] and [consumer] sections loaded
+func loadSinks(iniFile *ini.File) error {
+	section := iniFile.Section(Redis.SectionName())
+	Sinks = []*SinkConfig{
+		{
+			Name:          DefaultSink,
+			Enabled:       section.Key("sink_enabled").MustBool(true),
+			ConsumerGroup: Consumer.ConsumerGroup,
+			Redis:         Redis,
+		},
+	}
+
+	for _, section := range iniFile.Sections() {
+		if !strings.HasPrefix(section.Name(), sinkSectionPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(section.Name(), sinkSectionPrefix)
+		if name == "" || name == DefaultSink {
+			return fmt.Errorf("invalid sink name: %q", name)
+		}
+
+		own := make(map[string]string)
+		for _, key := range section.KeyStrings() {
+			own[key] = section.Key(key).String()
+		}
+
+		if _, ok := own["addrs"]; !ok {
+			return fmt.Errorf("no addrs of sink: %v", name)
+		}
+
+		sink := &SinkConfig{
+			Name:          name,
+			Enabled:       true,
+			ConsumerGroup: Consumer.ConsumerGroup + "." + name,
+			Redis:         &RedisConfig{Config: &rdb.Config{}},
+		}
+		if _, ok := own["sink_enabled"]; ok {
+			sink.Enabled = section.Key("sink_enabled").MustBool(true)
+		}
+		if group, ok := own["consumer_group"]; ok && group != "" {
+			sink.ConsumerGroup = group
+		}
+		if err := sink.Redis.Load(section); err != nil {
+			return fmt.Errorf("load sink: name=%v, error=%v", name, err)
+		}
+
+		Sinks = append(Sinks, sink)
+	}
+
+	return nil
+}
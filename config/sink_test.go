@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestWaitAppliedSink(t *testing.T) {
+	defer func(sinks []*SinkConfig, name string) {
+		Sinks, Proxy.WaitAppliedSink = sinks, name
+	}(Sinks, Proxy.WaitAppliedSink)
+
+	Sinks = []*SinkConfig{
+		{Name: DefaultSink, Enabled: false},
+		{Name: "a", Enabled: true},
+		{Name: "b", Enabled: true},
+		{Name: "c", Enabled: false},
+	}
+
+	tests := []struct {
+		name string
+		want string // empty for error
+	}{
+		{name: "", want: "a"},
+		{name: "b", want: "b"},
+		{name: DefaultSink},
+		{name: "c"},
+		{name: "x"},
+	}
+
+	for _, tt := range tests {
+		Proxy.WaitAppliedSink = tt.name
+
+		sink, err := WaitAppliedSink()
+		var got string
+		if err == nil {
+			got = sink.Name
+		}
+		if got != tt.want {
+			t.Errorf("WaitAppliedSink(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	Sinks = Sinks[:1]
+	Proxy.WaitAppliedSink = ""
+	if _, err := WaitAppliedSink(); err == nil {
+		t.Error("no enabled sink, want error")
+	}
+}
@@ -0,0 +1,202 @@
+package consumer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-redis/redis"
+
+	"github.com/stn81/nec/common/hashtag"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+const (
+	cmdMulti             = "multi"
+	idempotencyKeyPrefix = "nec:idem:"
+)
+
+var (
+	errTooFewArgs = errors.New("too few args")
+	errSkipped    = errors.New("skipped")
+	errNoRoute    = errors.New("key matches no route")
+	errNoTag      = errors.New("idempotency key can't share the slot of the key")
+)
+
+// applyScript applies the commands in order atomically. With a retention, the commands
+// are applied only if the idempotency key is not recorded yet, and the idempotency key is
+// dropped again if a command fails, so the retry can apply them. With a checkpoint, the
+// commands are applied only if the offset is above the checkpoint, which is advanced to
+// the offset with the commands.
+//
+// KEYS[1]: the idempotency key, or the first key of the commands without retention
+// KEYS[2]: the checkpoint key, if with a checkpoint
+// ARGV[1]: the retention seconds of the idempotency key, 0 for none
+// ARGV[2]: the checkpoint field, empty for none
+// ARGV[3]: the offset of the commands
+// ARGV[4:]: the commands, each is the number of args followed by the command and its args
+var applyScript = redis.NewScript(`
+local retention = tonumber(ARGV[1])
+local checkpoint = ARGV[2] ~= ''
+if checkpoint then
+	local applied = redis.call('HGET', KEYS[2], ARGV[2])
+	if applied and tonumber(applied) >= tonumber(ARGV[3]) then
+		return 0
+	end
+end
+if retention > 0 and not redis.call('SET', KEYS[1], 1, 'NX', 'EX', retention) then
+	if checkpoint then
+		redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])
+	end
+	return 0
+end
+local i = 4
+while i <= #ARGV do
+	local n = tonumber(ARGV[i])
+	local res = redis.pcall(unpack(ARGV, i + 1, i + n))
+	if type(res) == 'table' and res.err then
+		if retention > 0 then
+			redis.call('DEL', KEYS[1])
+		end
+		return res
+	end
+	i = i + n + 1
+end
+if checkpoint then
+	redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])
+end
+return 1
+`)
+
+// checkRequest checks the request decoded from kafka message
+func checkRequest(req *proxy.Request) error {
+	if strings.ToLower(req.Cmd) != cmdMulti {
+		if len(req.Args) < 1 {
+			return errTooFewArgs
+		}
+		return nil
+	}
+
+	if len(req.Multi) == 0 {
+		return errTooFewArgs
+	}
+	for _, sub := range req.Multi {
+		if len(sub.Args) < 1 {
+			return errTooFewArgs
+		}
+	}
+	return nil
+}
+
+// requestCommands returns the redis commands of the request
+func requestCommands(req *proxy.Request) [][]interface{} {
+	subs := []*proxy.Request{req}
+	if strings.ToLower(req.Cmd) == cmdMulti {
+		subs = req.Multi
+	}
+
+	cmds := make([][]interface{}, 0, len(subs))
+	for _, sub := range subs {
+		args := make([]interface{}, 0, len(sub.Args)+1)
+		args = append(args, sub.Cmd)
+		for i := range sub.Args {
+			args = append(args, sub.Args[i])
+		}
+		cmds = append(cmds, args)
+	}
+	return cmds
+}
+
+// Apply sends the commands of the request to redis. duplicate is true if the commands are
+// skipped because the idempotency key or the offset has been applied before, and errStale
+// is returned if skipped for an older version.
+func (r *redisSink) Apply(msg *sarama.ConsumerMessage, req *proxy.Request) (duplicate bool, err error) {
+	cmds := requestCommands(req)
+
+	// the keys share one route as checked by proxy
+	target, err := r.target(string(msg.Key))
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case r.versioned(msg, req):
+		return r.applyVersioned(target, msg, req)
+	case req.IdempotencyKey != "":
+		return r.applyIdempotent(target, msg, req, cmds)
+	case r.checkpointing(msg), len(cmds) > 1 && target.cluster:
+		// the keys share one slot as checked by proxy, so does the script
+		if err = r.runScript(target, msg, string(msg.Key), 0, cmds); err == errSkipped {
+			return true, nil
+		}
+		return false, err
+	case len(cmds) > 1:
+		_, err = target.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, args := range cmds {
+				pipe.Do(args...)
+			}
+			return nil
+		})
+		return false, err
+	default:
+		return false, target.client.Do(cmds[0]...).Err()
+	}
+}
+
+func (r *redisSink) applyIdempotent(target *redisTarget, msg *sarama.ConsumerMessage, req *proxy.Request, cmds [][]interface{}) (duplicate bool, err error) {
+	idemKey, ok := idempotencyKey(string(msg.Key), req.IdempotencyKey)
+	if !ok && target.cluster {
+		// rejected by proxy, unless produced before
+		return false, errNoTag
+	}
+
+	retention := int64(r.conf.IdemRetention.Seconds())
+	if err = r.runScript(target, msg, idemKey, retention, cmds); err == errSkipped {
+		return true, nil
+	}
+	return false, err
+}
+
+// idempotencyKey returns the idempotency key of the write to key. The idempotency key lives
+// in the same slot as the key, so that the check and the commands are atomic in one script
+// on redis cluster too. ok is false if it can't, and the untagged key is returned, which
+// is only atomic with the commands on a standalone redis.
+func idempotencyKey(key, idempotency string) (idemKey string, ok bool) {
+	tagged, ok := hashtag.Tagged(idempotencyKeyPrefix, key)
+	if !ok {
+		return idempotencyKeyPrefix + key + ":" + idempotency, false
+	}
+	return tagged + ":" + idempotency, true
+}
+
+// runScript runs the commands with applyScript, errSkipped is returned if they are skipped
+func (r *redisSink) runScript(target *redisTarget, msg *sarama.ConsumerMessage, key string, retention int64, cmds [][]interface{}) error {
+	keys, argv := r.scriptArgs(msg, key, retention, cmds)
+
+	applied, err := applyScript.Run(target.client, keys, argv...).Int()
+	switch {
+	case err != nil:
+		return err
+	case applied == 0:
+		return errSkipped
+	}
+	return nil
+}
+
+// scriptArgs returns the keys and args of applyScript, with the checkpoint of the message
+// if enabled. The checkpoint key is in the slot of the message key, so is key.
+func (r *redisSink) scriptArgs(msg *sarama.ConsumerMessage, key string, retention int64, cmds [][]interface{}) (keys []string, argv []interface{}) {
+	keys = []string{key}
+	argv = []interface{}{retention, "", msg.Offset}
+
+	if r.checkpointing(msg) {
+		keys = append(keys, r.checkpointKey(hashtag.Slot(string(msg.Key))))
+		argv[1] = msg.Partition
+	}
+
+	for _, args := range cmds {
+		argv = append(argv, len(args))
+		argv = append(argv, args...)
+	}
+	return keys, argv
+}
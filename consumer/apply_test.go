@@ -0,0 +1,45 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		idemKey string
+		ok      bool
+	}{
+		{key: "user:1", idemKey: "nec:idem:{user:1}:req1", ok: true},
+		{key: "{user:1}a", idemKey: "nec:idem:{user:1}:req1", ok: true},
+		// the untagged ones are scoped by the key too
+		{key: "a}b", idemKey: "nec:idem:a}b:req1"},
+		{key: "c}d", idemKey: "nec:idem:c}d:req1"},
+	}
+
+	for _, tt := range tests {
+		if idemKey, ok := idempotencyKey(tt.key, "req1"); idemKey != tt.idemKey || ok != tt.ok {
+			t.Errorf("idempotencyKey(%q) = %q, %v, want %q, %v", tt.key, idemKey, ok, tt.idemKey, tt.ok)
+		}
+	}
+}
+
+// TestApplyIdempotentNoTag checks the idempotent write of a key not taggable fails permanently
+// on redis cluster, instead of applied not atomically.
+func TestApplyIdempotentNoTag(t *testing.T) {
+	r := &redisSink{}
+	target := &redisTarget{cluster: true}
+	msg := &sarama.ConsumerMessage{Key: []byte("a}b")}
+	req := &proxy.Request{Cmd: "set", Args: [][]byte{[]byte("a}b"), []byte("v")}, IdempotencyKey: "req1"}
+
+	if _, err := r.applyIdempotent(target, msg, req, requestCommands(req)); err != errNoTag {
+		t.Fatalf("err = %v, want %v", err, errNoTag)
+	}
+	if !newErrorClassifier(nil, nil).permanent(errNoTag) {
+		t.Fatal("errNoTag not permanent")
+	}
+}
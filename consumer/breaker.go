@@ -0,0 +1,216 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/config"
+)
+
+// the states of breaker
+const (
+	breakerClosed = "closed"
+	breakerOpen   = "open"
+)
+
+// breaker trips when the sink is unhealthy, on the error rate or latency of the applies in
+// the window, or the overload reported by the probe, like the memory usage in redis INFO. The lanes wait while it is open, so that the
+// claims stop consuming and nothing is marked, as sarama has no pause API in the version.
+// When open, it probes the sink and closes after enough healthy probes in a row.
+type breaker struct {
+	conf    config.ConsumerConfig
+	sink    Sink
+	logger  *zap.Logger
+	mu      sync.Mutex
+	state   string
+	reason  string
+	closed  chan struct{} // closed when the breaker is closed
+	window  time.Time
+	reqs    int
+	errs    int
+	latency time.Duration
+	wg      sync.WaitGroup
+	stopC   chan struct{}
+	gauge   prometheus.Gauge
+	trips   prometheus.Counter
+}
+
+func newBreaker(conf config.ConsumerConfig, sink Sink, logger *zap.Logger, labels prometheus.Labels) *breaker {
+	b := &breaker{
+		conf:   conf,
+		sink:   sink,
+		logger: logger,
+		state:  breakerClosed,
+		closed: make(chan struct{}),
+		window: time.Now(),
+		stopC:  make(chan struct{}),
+		gauge: promauto.NewGauge(prometheus.GaugeOpts{
+			Name:        "consumer_breaker_open",
+			Help:        "Whether the redis circuit breaker of consumer is open",
+			ConstLabels: labels,
+		}),
+		trips: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_breaker_trips_total",
+			Help:        "The number of times the redis circuit breaker of consumer tripped",
+			ConstLabels: labels,
+		}),
+	}
+	close(b.closed)
+	return b
+}
+
+func (b *breaker) Start() {
+	if !b.conf.BreakerEnabled {
+		return
+	}
+
+	b.wg.Add(1)
+	go b.loop()
+}
+
+func (b *breaker) Stop() {
+	close(b.stopC)
+	b.wg.Wait()
+}
+
+// Status returns the state of breaker, with the trip reason if open
+func (b *breaker) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		return b.state + ": " + b.reason
+	}
+	return b.state
+}
+
+// isOpen returns whether the breaker is open
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen
+}
+
+// wait waits until the breaker is closed, false is returned if ctx is done before it
+func (b *breaker) wait(ctx context.Context) bool {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	select {
+	case <-closed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// record records the applies and the transient errors in them, the permanent errors tell
+// nothing about the redis health. latency is of each apply.
+func (b *breaker) record(reqs, errs int, latency time.Duration) {
+	if !b.conf.BreakerEnabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		return
+	}
+
+	if now := time.Now(); now.Sub(b.window) >= b.conf.BreakerWindow {
+		b.window, b.reqs, b.errs, b.latency = now, 0, 0, 0
+	}
+
+	b.reqs += reqs
+	b.errs += errs
+	b.latency += latency * time.Duration(reqs)
+
+	if b.reqs < b.conf.BreakerMinRequests {
+		return
+	}
+
+	if rate := float64(b.errs) / float64(b.reqs); rate >= b.conf.BreakerErrorRate {
+		b.trip(fmt.Sprintf("error rate %.2f", rate))
+		return
+	}
+
+	if b.conf.BreakerLatency > 0 {
+		if avg := b.latency / time.Duration(b.reqs); avg >= b.conf.BreakerLatency {
+			b.trip(fmt.Sprintf("latency %v", avg))
+		}
+	}
+}
+
+// trip opens the breaker, requires lock
+func (b *breaker) trip(reason string) {
+	if b.state == breakerOpen {
+		return
+	}
+
+	b.state = breakerOpen
+	b.reason = reason
+	b.closed = make(chan struct{})
+	b.gauge.Set(1)
+	b.trips.Inc()
+	b.logger.Error("redis circuit breaker tripped, consumption paused", zap.String("reason", reason))
+}
+
+// reset closes the breaker, requires lock
+func (b *breaker) reset() {
+	if b.state == breakerClosed {
+		return
+	}
+
+	b.state = breakerClosed
+	b.reason = ""
+	b.window, b.reqs, b.errs, b.latency = time.Now(), 0, 0, 0
+	close(b.closed)
+	b.gauge.Set(0)
+	b.logger.Info("redis circuit breaker closed, consumption resumed")
+}
+
+func (b *breaker) loop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.conf.BreakerProbeInterval)
+	defer ticker.Stop()
+
+	healthy := 0
+	for {
+		select {
+		case <-b.stopC:
+			return
+		case <-ticker.C:
+		}
+
+		err := b.sink.Probe()
+
+		b.mu.Lock()
+		switch {
+		case err != nil && b.state == breakerClosed:
+			// only the overload trips the breaker in probe, the other errors are counted
+			// by the applies
+			if err, ok := err.(overloadError); ok {
+				b.trip(string(err))
+			}
+			healthy = 0
+		case err != nil:
+			b.reason = err.Error()
+			healthy = 0
+		case b.state == breakerOpen:
+			if healthy++; healthy >= b.conf.BreakerProbeSuccesses {
+				b.reset()
+				healthy = 0
+			}
+		}
+		b.mu.Unlock()
+	}
+}
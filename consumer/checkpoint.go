@@ -0,0 +1,55 @@
+package consumer
+
+import (
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/common/hashtag"
+	"github.com/stn81/nec/config"
+)
+
+// The applied offsets are checkpointed by the sink with the requests atomically, per slot
+// and partition. The messages of a slot are applied in offset order on one lane, so that
+// the offset at or below the checkpoint of its slot has been applied.
+
+// loadCheckpoints loads the checkpoints of the claimed partitions, to skip the applied
+// messages without the round trip to sink. It is only an optimization, as the sink checks
+// the checkpoint too.
+func (s *consumerService) loadCheckpoints(session sarama.ConsumerGroupSession) {
+	s.checkpoints = nil
+
+	partitions := session.Claims()[config.Kafka.Topic]
+	if len(partitions) == 0 {
+		return
+	}
+
+	checkpoints, err := s.sink.Checkpoints(partitions)
+	if err != nil {
+		s.logger.Error("failed to load checkpoints", zap.Error(err))
+		return
+	}
+
+	s.checkpoints = checkpoints
+	s.logger.Info("checkpoints loaded", zap.Int32s("partitions", partitions))
+}
+
+// checkpointed returns whether the message is at or below the loaded checkpoint
+func (s *consumerService) checkpointed(msg *sarama.ConsumerMessage) bool {
+	if msg.Topic != config.Kafka.Topic {
+		return false
+	}
+	offsets, ok := s.checkpoints[msg.Partition]
+	if !ok {
+		return false
+	}
+	return msg.Offset <= offsets[hashtag.Slot(string(msg.Key))]
+}
+
+// laneKey returns the key to order the message by, the slot of its key. The message key is
+// only the first key of a multi or a split part, while all its keys share the slot, which
+// the proxy requires even if redis is not a cluster.
+func (s *consumerService) laneKey(msg *sarama.ConsumerMessage) string {
+	return strconv.Itoa(hashtag.Slot(string(msg.Key)))
+}
@@ -0,0 +1,70 @@
+package consumer
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// errorClassifier tells the permanent redis errors, which fail the same on retry, from
+// the transient ones. The longest matched prefix of the error message decides, and the
+// unmatched errors are transient.
+type errorClassifier struct {
+	rules []errorRule
+}
+
+type errorRule struct {
+	prefix    string
+	permanent bool
+}
+
+func newErrorClassifier(transient, permanent []string) *errorClassifier {
+	c := &errorClassifier{}
+	for _, prefix := range transient {
+		c.rules = append(c.rules, errorRule{prefix: prefix})
+	}
+	for _, prefix := range permanent {
+		c.rules = append(c.rules, errorRule{prefix: prefix, permanent: true})
+	}
+
+	sort.SliceStable(c.rules, func(i, j int) bool {
+		return len(c.rules[i].prefix) > len(c.rules[j].prefix)
+	})
+	return c
+}
+
+// permanent returns whether the error should fail without retry
+func (c *errorClassifier) permanent(err error) bool {
+	if err == errNoRoute || err == errNoTag {
+		return true
+	}
+	if err == nil || !isRedisError(err) {
+		return false
+	}
+
+	msg := err.Error()
+	for _, rule := range c.rules {
+		if strings.HasPrefix(msg, rule.prefix) {
+			return rule.permanent
+		}
+	}
+	return false
+}
+
+// isRedisError returns whether the error is replied by redis, the others are network or
+// client errors.
+func isRedisError(err error) bool {
+	if _, ok := err.(net.Error); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false
+	}
+
+	// the error type of redis reply is internal to go-redis, whose messages begin with
+	// an upper-case error code, like "ERR" or "WRONGTYPE", as defined by the protocol.
+	msg := err.Error()
+	code := msg
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		code = msg[:i]
+	}
+	return code != "" && strings.ToUpper(code) == code && !strings.HasPrefix(msg, "redis:")
+}
@@ -6,10 +6,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/stn81/nec/common/dlq"
+	"github.com/stn81/nec/common/health"
+	"github.com/stn81/nec/common/partitioner"
 	"github.com/stn81/nec/config"
 	"github.com/stn81/nec/proto/proxy"
 	"github.com/stn81/kate/log"
-	"github.com/stn81/kate/rdb"
 	"github.com/stn81/retry"
 	"github.com/Shopify/sarama"
 	"github.com/golang/protobuf/proto"
@@ -20,13 +22,21 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-var gService *consumerService
+var gServices []*consumerService
 
+// consumerService consumes the topic and applies the requests to a sink
 type consumerService struct {
 	conf         config.ConsumerConfig
 	ready        chan bool
+	kafka        sarama.Client
 	client       sarama.ConsumerGroup
-	redis        rdb.Client
+	partitions   int32
+	checkpoints  map[int32][]int64
+	producer     sarama.SyncProducer
+	scheduler    *scheduler
+	sink         Sink
+	classifier   *errorClassifier
+	breaker      *breaker
 	tokenBucket  *ratelimit.Bucket
 	logger       *zap.Logger
 	accessLogger *zap.Logger
@@ -36,53 +46,52 @@ type consumerService struct {
 	total        prometheus.Counter
 	succ         prometheus.Counter
 	fail         prometheus.Counter
+	duplicate    prometheus.Counter
+	expired      prometheus.Counter
+	stale        prometheus.Counter
+	scheduled    prometheus.Counter
+	misplaced    prometheus.Counter
+	deadLettered prometheus.Counter
 	processTime  prometheus.Histogram
 }
 
+// Start starts a consumer for each enabled sink
 func Start(logger *zap.Logger) {
-	if gService != nil {
+	if gServices != nil {
 		panic("consumer start twice")
 	}
 
-	gService = &consumerService{
-		conf:   *config.Consumer,
-		ready:  make(chan bool),
-		logger: logger.Named("consumer"),
-		total: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "consumer_processed_total",
-			Help: "The total number of processed messages by consumer",
-		}),
-		succ: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "consumer_processed_succ",
-			Help: "The succ number of processed messages by consumer",
-		}),
-		fail: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "consumer_processed_fail",
-			Help: "The fail number of processed messages by consumer",
-		}),
-		processTime: promauto.NewHistogram(prometheus.HistogramOpts{
-			Name: "consumer_process_time_ms",
-			Help: "The process time of consumer message in ms",
-		}),
+	logger = logger.Named("consumer")
+	accessLogger := newAccessLogger()
+
+	gServices = make([]*consumerService, 0, len(config.Sinks))
+	for _, sinkConf := range config.Sinks {
+		if !sinkConf.Enabled {
+			logger.Info("sink disabled", zap.String("sink", sinkConf.Name))
+			continue
+		}
+
+		s := newConsumerService(sinkConf, logger, accessLogger)
+		s.start()
+		gServices = append(gServices, s)
 	}
-	gService.start()
 }
 
 func Stop() {
-	if gService != nil {
-		gService.stop()
+	for _, s := range gServices {
+		s.stop()
 	}
 }
 
-func (s *consumerService) start() {
+func newAccessLogger() *zap.Logger {
 	loggerCfg := zap.NewProductionEncoderConfig()
 	loggerCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	enc := zapcore.NewJSONEncoder(loggerCfg)
 	core := zapcore.NewSampler(
-		log.MustNewCoreWithLevelAbove(zapcore.InfoLevel, path.Join(config.Main.LogDir, s.conf.LogFile), enc),
-		s.conf.LogSampler.Tick,
-		s.conf.LogSampler.First,
-		s.conf.LogSampler.ThereAfter,
+		log.MustNewCoreWithLevelAbove(zapcore.InfoLevel, path.Join(config.Main.LogDir, config.Consumer.LogFile), enc),
+		config.Consumer.LogSampler.Tick,
+		config.Consumer.LogSampler.First,
+		config.Consumer.LogSampler.ThereAfter,
 	)
 
 	opts := []zap.Option{
@@ -90,32 +99,128 @@ func (s *consumerService) start() {
 		zap.AddCaller(),
 	}
 
+	return zap.New(core, opts...)
+}
+
+func newConsumerService(sinkConf *config.SinkConfig, logger, accessLogger *zap.Logger) *consumerService {
+	conf := *config.Consumer
+	conf.ConsumerGroup = sinkConf.ConsumerGroup
+
+	logger = logger.With(zap.String("sink", sinkConf.Name))
+	labels := prometheus.Labels{"sink": sinkConf.Name}
+
+	return &consumerService{
+		conf:         conf,
+		ready:        make(chan bool),
+		sink:         newRedisSink(sinkConf, conf, logger),
+		logger:       logger,
+		accessLogger: accessLogger.With(zap.String("sink", sinkConf.Name)),
+		total: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_total",
+			Help:        "The total number of processed messages by consumer",
+			ConstLabels: labels,
+		}),
+		succ: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_succ",
+			Help:        "The succ number of processed messages by consumer",
+			ConstLabels: labels,
+		}),
+		fail: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_fail",
+			Help:        "The fail number of processed messages by consumer",
+			ConstLabels: labels,
+		}),
+		duplicate: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_duplicate",
+			Help:        "The number of messages skipped by consumer for duplicate idempotency key",
+			ConstLabels: labels,
+		}),
+		expired: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_expired",
+			Help:        "The number of messages skipped by consumer for past the deadline",
+			ConstLabels: labels,
+		}),
+		stale: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_stale",
+			Help:        "The number of messages skipped by consumer for an older version than applied",
+			ConstLabels: labels,
+		}),
+		scheduled: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_processed_scheduled",
+			Help:        "The number of messages parked by consumer in the delay topic until due",
+			ConstLabels: labels,
+		}),
+		misplaced: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_misplaced_total",
+			Help:        "The number of messages on an unexpected partition by the slot partitioner",
+			ConstLabels: labels,
+		}),
+		deadLettered: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_dead_letter_total",
+			Help:        "The number of messages sent to the dead-letter topic by consumer",
+			ConstLabels: labels,
+		}),
+		processTime: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:        "consumer_process_time_ms",
+			Help:        "The process time of consumer message in ms",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (s *consumerService) start() {
 	s.tokenBucket = ratelimit.NewBucketWithRate(float64(s.conf.TPSLimit), s.conf.TPSLimit)
 
-	s.accessLogger = zap.New(core, opts...)
+	s.classifier = newErrorClassifier(s.conf.TransientErrors, s.conf.PermanentErrors)
+
+	s.sink.Start()
 
-	s.redis = rdb.Get()
+	s.breaker = newBreaker(s.conf, s.sink, s.logger, prometheus.Labels{"sink": s.sink.Name()})
+	s.breaker.Start()
+	health.Register(s.breakerName(), s.breaker.Status)
 
 	clientConf := sarama.NewConfig()
 	clientConf.Version = config.Kafka.Version
 	clientConf.ClientID = config.Kafka.ClientID
 	clientConf.Consumer.Group.Rebalance.Strategy = s.conf.BalanceStrategy
+	clientConf.Producer.RequiredAcks = sarama.WaitForAll
+	clientConf.Producer.Return.Successes = true
 
 	var err error
-	s.client, err = sarama.NewConsumerGroup(
-		config.Kafka.BrokerAddrs,
-		s.conf.ConsumerGroup,
-		clientConf,
-	)
+	if s.kafka, err = sarama.NewClient(config.Kafka.BrokerAddrs, clientConf); err != nil {
+		s.logger.Fatal("failed to create kafka client", zap.Error(err))
+	}
+
+	if s.conf.DeadLetterTopic != "" || s.conf.DelayTopic != "" {
+		if s.producer, err = sarama.NewSyncProducerFromClient(s.kafka); err != nil {
+			s.logger.Fatal("failed to create producer", zap.Error(err))
+		}
+	}
+
+	s.client, err = sarama.NewConsumerGroupFromClient(s.conf.ConsumerGroup, s.kafka)
 	if err != nil {
 		s.logger.Fatal("failed to create consumer group", zap.Error(err))
 	}
 
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 
-	gService.wg.Add(1)
-	go gService.serve()
-	<-gService.ready
+	if s.conf.DelayTopic != "" {
+		s.scheduler = newScheduler(s)
+		s.wg.Add(1)
+		go s.scheduler.serve()
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	<-s.ready
+}
+
+// breakerName returns the name of the breaker in health status
+func (s *consumerService) breakerName() string {
+	if s.sink.Name() == config.DefaultSink {
+		return "redis_breaker"
+	}
+	return "redis_breaker." + s.sink.Name()
 }
 
 func (s *consumerService) serve() {
@@ -143,62 +248,211 @@ func (s *consumerService) serve() {
 func (s *consumerService) stop() {
 	s.cancel()
 	s.wg.Wait()
+	health.Unregister(s.breakerName())
+	s.breaker.Stop()
+	s.sink.Stop()
 	if err := s.client.Close(); err != nil {
 		s.logger.Fatal("failed to close consumer client", zap.Error(err))
 	}
+	if s.scheduler != nil {
+		s.scheduler.close()
+	}
+	if s.producer != nil {
+		if err := s.producer.Close(); err != nil {
+			s.logger.Error("failed to close producer", zap.Error(err))
+		}
+	}
+	if err := s.kafka.Close(); err != nil {
+		s.logger.Error("failed to close kafka client", zap.Error(err))
+	}
 }
 
 func (s *consumerService) Setup(session sarama.ConsumerGroupSession) error {
+	if config.Proxy.Partitioner == partitioner.ModeSlot {
+		s.loadPartitions()
+	}
+
+	if s.conf.CheckpointEnabled {
+		s.loadCheckpoints(session)
+	}
+
 	close(s.ready)
 	return nil
 }
 
+// loadPartitions loads the partition count of topic to verify the slot partitioning
+func (s *consumerService) loadPartitions() {
+	s.partitions = 0
+
+	if err := s.kafka.RefreshMetadata(config.Kafka.Topic); err != nil {
+		s.logger.Error("failed to refresh topic metadata", zap.String("topic", config.Kafka.Topic), zap.Error(err))
+		return
+	}
+
+	partitions, err := s.kafka.Partitions(config.Kafka.Topic)
+	if err != nil {
+		s.logger.Error("failed to get partition list", zap.String("topic", config.Kafka.Topic), zap.Error(err))
+		return
+	}
+
+	s.partitions = int32(len(partitions))
+}
+
+// verifyPartition warns if the message is not on the partition of its slot, which happens
+// when the partition count has grown since it was produced, or the producer is misconfigured.
+func (s *consumerService) verifyPartition(logger *zap.Logger, msg *sarama.ConsumerMessage) {
+	if s.partitions <= 0 || msg.Key == nil || msg.Topic != config.Kafka.Topic {
+		return
+	}
+
+	if expected := partitioner.Partition(msg.Key, s.partitions); expected != msg.Partition {
+		s.misplaced.Inc()
+		logger.Warn("message on unexpected partition",
+			zap.Int32("expected_partition", expected),
+			zap.Int32("partitions", s.partitions),
+		)
+	}
+}
+
 func (s *consumerService) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
 func (s *consumerService) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	lanes := s.newClaimLanes(session)
 	for msg := range claim.Messages() {
-		begin := time.Now()
+		if !lanes.dispatch(msg) {
+			break
+		}
+	}
+	lanes.close()
 
-		s.total.Inc()
+	return nil
+}
 
-		logger := s.logger.With(
-			zap.String("topic", msg.Topic),
-			zap.Int32("partition", msg.Partition),
-			zap.Int64("offset", msg.Offset),
-			zap.String("key", string(msg.Key)),
-		)
+// task is a decoded message to apply, ctx is done when the claim session ends
+type task struct {
+	ctx       context.Context
+	msg       *sarama.ConsumerMessage
+	req       *proxy.Request
+	logger    *zap.Logger
+	begin     time.Time
+	duplicate bool
+	expired   bool
+	stale     bool
+	scheduled bool
+	attempts  int
+	err       error
+}
 
-		req := &proxy.Request{}
-		if err := proto.Unmarshal(msg.Value, req); err != nil {
-			logger.Error("failed to parse request", zap.Error(err))
-			session.MarkMessage(msg, "")
-			s.fail.Inc()
-			continue
+// process applies the message to redis, or sends it to the dead-letter topic if failed.
+// It returns false if the consumer is stopping or the claim session ends before the message
+// is done, the message must not be marked then.
+func (s *consumerService) process(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	t, ok := s.decode(ctx, msg)
+	if t == nil {
+		return ok
+	}
+
+	s.tokenBucket.Wait(1)
+
+	return s.execute(t)
+}
+
+// decode decodes and checks the request in the message. A nil task is returned if the
+// message is invalid, which is done after sent to the dead-letter topic, or not done if
+// the consumer is stopping. The expired message, and the scheduled one parked in the delay
+// topic, are done without a task too.
+func (s *consumerService) decode(ctx context.Context, msg *sarama.ConsumerMessage) (t *task, ok bool) {
+	begin := time.Now()
+
+	s.total.Inc()
+
+	logger := s.logger.With(
+		zap.String("topic", msg.Topic),
+		zap.Int32("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
+		zap.String("key", string(msg.Key)),
+	)
+
+	s.verifyPartition(logger, msg)
+
+	if s.conf.CheckpointEnabled && s.checkpointed(msg) {
+		s.duplicate.Inc()
+		logger.Info("message skipped below checkpoint")
+		return nil, true
+	}
+
+	req := &proxy.Request{}
+	if err := proto.Unmarshal(msg.Value, req); err != nil {
+		logger.Error("failed to parse request", zap.Error(err))
+		if !s.deadLetter(logger, msg, dlq.ReasonDecode, 0, err) {
+			return nil, false
 		}
+		s.fail.Inc()
+		return nil, true
+	}
 
-		if len(req.Args) < 1 {
-			logger.Error("too few args")
-			session.MarkMessage(msg, "")
-			s.fail.Inc()
-			continue
+	if err := checkRequest(req); err != nil {
+		logger.Error("invalid request", zap.Error(err))
+		if !s.deadLetter(logger, msg, dlq.ReasonInvalid, 0, err) {
+			return nil, false
 		}
+		s.fail.Inc()
+		return nil, true
+	}
+
+	t = &task{ctx: ctx, msg: msg, req: req, logger: logger, begin: begin}
+	if s.pastDeadline(msg, req, begin) {
+		t.expired = true
+		return nil, s.finish(t, true)
+	}
 
-		args := make([]interface{}, 0, len(req.Args)+1)
-		args = append(args, req.Cmd)
-		for i := range req.Args {
-			args = append(args, req.Args[i])
+	if at := applyAt(req); msg.Topic == config.Kafka.Topic && at.After(begin) && s.conf.DelayTopic != "" {
+		if !s.park(logger, msg, at) {
+			return nil, false
 		}
+		t.scheduled = true
+		return nil, s.finish(t, true)
+	}
+	return t, true
+}
 
-		s.tokenBucket.Wait(1)
+// execute applies the task with retries, and finishes it. The permanent error fails
+// the task without retry. The transient errors never fail the task while the breaker is
+// open, it is retried again once redis is healthy.
+func (s *consumerService) execute(t *task) bool {
+	for {
+		var (
+			permanent bool
+			cancel    = &retry.CancelableRetryStrategy{}
+			strategy  = append(retry.All{cancel}, s.getRetryStrategy()...)
+		)
+		success := retry.Do(t.ctx, strategy, func() bool {
+			var err error
+			t.attempts++
+			begin := time.Now()
+			t.duplicate, err = s.sink.Apply(t.msg, t.req)
+			if t.stale = err == errStale; t.stale {
+				err = nil
+			}
+			permanent = err != nil && s.classifier.permanent(err)
+
+			if err != nil && !permanent {
+				s.breaker.record(1, 1, time.Since(begin))
+			} else {
+				s.breaker.record(1, 0, time.Since(begin))
+			}
 
-		strategy := s.getRetryStrategy()
-		success := retry.Do(s.ctx, strategy, func() bool {
-			if _, err := s.redis.Do(args...).Result(); err != nil {
-				logger.Error("failed to proxy redis command",
-					zap.String("command", string(req.Cmd)),
+			if err != nil {
+				t.err = err
+				if permanent || s.breaker.isOpen() {
+					cancel.Cancel()
+				}
+				t.logger.Error("failed to proxy redis command",
+					zap.String("command", string(t.req.Cmd)),
 					zap.Error(err),
+					zap.Bool("permanent", permanent),
 					zap.Bool("will_retry", strategy.HasNext()),
 				)
 				return false
@@ -206,42 +460,93 @@ func (s *consumerService) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 			return true
 		})
 
-		if success {
-			s.succ.Inc()
-		} else {
-			s.fail.Inc()
+		if !success && !permanent && s.breaker.isOpen() {
+			if !s.breaker.wait(t.ctx) {
+				return false
+			}
+			continue
 		}
 
-		session.MarkMessage(msg, "")
+		return s.finish(t, success)
+	}
+}
 
-		elapsed := time.Since(begin).Milliseconds()
+// finish sends the failed task to the dead-letter topic, and counts and logs the task
+func (s *consumerService) finish(t *task, success bool) bool {
+	msg := t.msg
 
-		s.processTime.Observe(float64(elapsed))
+	if !success {
+		if t.ctx.Err() != nil {
+			// stopping, leave the message unmarked to be consumed again
+			return false
+		}
+		if !s.deadLetter(t.logger, msg, dlq.ReasonExhausted, t.attempts, t.err) {
+			return false
+		}
+	}
 
-		s.accessLogger.Info("message claimed",
-			zap.String("topic", msg.Topic),
-			zap.Int32("partition", msg.Partition),
-			zap.Int64("offset", msg.Offset),
-			zap.String("key", string(msg.Key)),
-			zap.String("command", req.Cmd),
-			zap.Time("timestamp", msg.Timestamp),
-			zap.Bool("success", success),
-			zap.Int64("wait_ms", begin.Sub(msg.Timestamp).Milliseconds()),
-			zap.Int64("elapsed_ms", elapsed),
-		)
+	switch {
+	case t.expired:
+		s.expired.Inc()
+	case t.scheduled:
+		s.scheduled.Inc()
+	case success && t.duplicate:
+		s.duplicate.Inc()
+	case success && t.stale:
+		s.stale.Inc()
+		t.logger.Info("stale write skipped", zap.String("command", t.req.Cmd), zap.Int64("version", t.req.Version))
+	case success:
+		s.succ.Inc()
+	default:
+		s.fail.Inc()
 	}
 
-	return nil
+	elapsed := time.Since(t.begin).Milliseconds()
+
+	s.processTime.Observe(float64(elapsed))
+
+	s.accessLogger.Info("message claimed",
+		zap.String("topic", msg.Topic),
+		zap.Int32("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
+		zap.String("key", string(msg.Key)),
+		zap.String("command", t.req.Cmd),
+		zap.Time("timestamp", msg.Timestamp),
+		zap.Bool("success", success),
+		zap.Bool("duplicate", t.duplicate),
+		zap.Bool("expired", t.expired),
+		zap.Bool("stale", t.stale),
+		zap.Bool("scheduled", t.scheduled),
+		zap.Int32("merged", t.req.Merged),
+		zap.Int("attempts", t.attempts),
+		zap.Int64("wait_ms", t.begin.Sub(msg.Timestamp).Milliseconds()),
+		zap.Int64("elapsed_ms", elapsed),
+	)
+
+	return true
 }
 
-func (s *consumerService) getRetryStrategy() retry.Strategy {
-	return &retry.All{
-		&retry.ExponentialBackoffStrategy{
-			InitialDelay: time.Millisecond * 20,
-			MaxDelay:     time.Millisecond * 500,
-		},
+// markMessage marks the message as consumed, and publishes it as applied
+func (s *consumerService) markMessage(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
+	session.MarkMessage(msg, "")
+	s.sink.Applied(msg.Partition, msg.Offset)
+}
+
+// getRetryStrategy returns the configured retry strategy, the limits go before the backoff
+// to give up without the last delay.
+func (s *consumerService) getRetryStrategy() retry.All {
+	strategy := retry.All{
 		&retry.CountStrategy{
 			Tries: s.conf.MaxRetries,
 		},
 	}
+	if s.conf.RetryMaxElapsed > 0 {
+		strategy = append(strategy, &retry.MaximumTimeStrategy{
+			Duration: s.conf.RetryMaxElapsed,
+		})
+	}
+	return append(strategy, &retry.ExponentialBackoffStrategy{
+		InitialDelay: s.conf.RetryInitial,
+		MaxDelay:     s.conf.RetryMaxDelay,
+	})
 }
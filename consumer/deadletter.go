@@ -0,0 +1,70 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stn81/retry"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/common/dlq"
+)
+
+// deadLetter produces the failed message with the failure info to the dead-letter topic.
+// It retries until success or the consumer is stopping, false is returned for the later,
+// and the message should not be marked then. The message is dropped if no dead-letter
+// topic configured.
+func (s *consumerService) deadLetter(logger *zap.Logger, msg *sarama.ConsumerMessage, reason string, attempts int, cause error) bool {
+	if s.conf.DeadLetterTopic == "" {
+		logger.Warn("message dropped", zap.String("reason", reason))
+		return true
+	}
+
+	meta := &dlq.Meta{
+		Sink:      s.sink.Name(),
+		Reason:    reason,
+		Attempts:  attempts,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      time.Now(),
+	}
+	if cause != nil {
+		meta.Error = cause.Error()
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:   s.conf.DeadLetterTopic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: meta.Headers(),
+	}
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	strategy := &retry.ExponentialBackoffStrategy{
+		InitialDelay: time.Millisecond * 100,
+		MaxDelay:     time.Second * 5,
+	}
+	success := retry.Do(s.ctx, strategy, func() bool {
+		if _, _, err := s.producer.SendMessage(message); err != nil {
+			logger.Error("failed to send message to dead-letter topic",
+				zap.String("dead_letter_topic", s.conf.DeadLetterTopic),
+				zap.Error(err),
+			)
+			return false
+		}
+		return true
+	})
+	if !success {
+		return false
+	}
+
+	s.deadLettered.Inc()
+	logger.Warn("message sent to dead-letter topic",
+		zap.String("dead_letter_topic", s.conf.DeadLetterTopic),
+		zap.String("reason", reason),
+		zap.Int("attempts", attempts),
+	)
+	return true
+}
@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// deadline returns the deadline of the request, the earlier of the one by client and the
+// one by the max age of the commands since the message produced or scheduled. The zero time is for none.
+func (s *consumerService) deadline(msg *sarama.ConsumerMessage, req *proxy.Request) time.Time {
+	var deadline time.Time
+	if req.DeadlineMs > 0 {
+		deadline = time.Unix(0, req.DeadlineMs*int64(time.Millisecond))
+	}
+
+	// the age of a scheduled write counts from its apply time
+	produced := msg.Timestamp
+	if at := applyAt(req); at.After(produced) {
+		produced = at
+	}
+	if len(s.conf.MaxAges) == 0 || produced.IsZero() {
+		return deadline
+	}
+
+	subs := []*proxy.Request{req}
+	if strings.ToLower(req.Cmd) == cmdMulti {
+		subs = req.Multi
+	}
+	for _, sub := range subs {
+		age, ok := s.conf.MaxAges[strings.ToLower(sub.Cmd)]
+		if !ok {
+			if age, ok = s.conf.MaxAges["*"]; !ok {
+				continue
+			}
+		}
+		if expire := produced.Add(age); deadline.IsZero() || expire.Before(deadline) {
+			deadline = expire
+		}
+	}
+	return deadline
+}
+
+// pastDeadline returns whether the request is past its deadline at now
+func (s *consumerService) pastDeadline(msg *sarama.ConsumerMessage, req *proxy.Request, now time.Time) bool {
+	deadline := s.deadline(msg, req)
+	return !deadline.IsZero() && now.After(deadline)
+}
@@ -0,0 +1,208 @@
+package consumer
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const laneQueueSize = 128
+
+// claimLanes applies the messages of a claim on parallel lanes, the messages of a slot are
+// always on the same lane to keep them in order. Each lane applies its queued messages in
+// batches. The offset is marked only after all the earlier messages of the partition are done.
+type claimLanes struct {
+	s        *consumerService
+	session  sarama.ConsumerGroupSession
+	lanes    []chan *laneMessage
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	inflight []*laneMessage // in offset order
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+type laneMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func (s *consumerService) newClaimLanes(session sarama.ConsumerGroupSession) *claimLanes {
+	n := s.conf.Lanes
+	if n < 1 {
+		n = 1
+	}
+
+	l := &claimLanes{
+		s:       s,
+		session: session,
+		lanes:   make([]chan *laneMessage, n),
+		stopped: make(chan struct{}),
+	}
+
+	for i := range l.lanes {
+		l.lanes[i] = make(chan *laneMessage, laneQueueSize)
+		l.wg.Add(1)
+		go l.work(l.lanes[i])
+	}
+	return l
+}
+
+// dispatch queues the message on the lane of its slot, false is returned if the lanes
+// are stopped or the claim session ends.
+func (l *claimLanes) dispatch(msg *sarama.ConsumerMessage) bool {
+	lm := &laneMessage{msg: msg}
+
+	l.mu.Lock()
+	l.inflight = append(l.inflight, lm)
+	l.mu.Unlock()
+
+	select {
+	case l.lanes[l.laneOf(l.s.laneKey(msg))] <- lm:
+		return true
+	case <-l.stopped:
+		return false
+	case <-l.session.Context().Done():
+		l.stop()
+		return false
+	}
+}
+
+// close waits the queued messages done
+func (l *claimLanes) close() {
+	for _, lane := range l.lanes {
+		close(lane)
+	}
+	l.wg.Wait()
+}
+
+func (l *claimLanes) stop() {
+	l.stopOnce.Do(func() { close(l.stopped) })
+}
+
+func (l *claimLanes) laneOf(key string) int {
+	if len(l.lanes) == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(l.lanes)))
+}
+
+func (l *claimLanes) work(lane chan *laneMessage) {
+	defer l.wg.Done()
+
+	var (
+		batch []*laneMessage
+		carry *laneMessage
+	)
+	for open := true; open; {
+		batch, carry, open = l.collect(lane, carry)
+		l.run(batch)
+	}
+}
+
+// collect collects a batch from the lane, up to the batch size or the linger time. A
+// message with the lane key already in the batch is carried to the next batch. open is false
+// if the lane is closed.
+func (l *claimLanes) collect(lane chan *laneMessage, first *laneMessage) (batch []*laneMessage, carry *laneMessage, open bool) {
+	if first == nil {
+		if first, open = <-lane; !open {
+			return nil, nil, false
+		}
+	}
+
+	batch = []*laneMessage{first}
+	keys := map[string]struct{}{l.s.laneKey(first.msg): {}}
+
+	var linger <-chan time.Time
+	if l.s.conf.BatchLinger > 0 {
+		timer := time.NewTimer(l.s.conf.BatchLinger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+
+	for len(batch) < l.s.conf.BatchSize {
+		var lm *laneMessage
+		if linger == nil {
+			select {
+			case lm, open = <-lane:
+			default:
+				return batch, nil, true
+			}
+		} else {
+			select {
+			case lm, open = <-lane:
+			case <-linger:
+				return batch, nil, true
+			}
+		}
+
+		if !open {
+			return batch, nil, false
+		}
+
+		key := l.s.laneKey(lm.msg)
+		if _, ok := keys[key]; ok {
+			return batch, lm, true
+		}
+		keys[key] = struct{}{}
+		batch = append(batch, lm)
+	}
+	return batch, nil, true
+}
+
+// run applies the batch, and stops the lanes if the consumer is stopping
+func (l *claimLanes) run(batch []*laneMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	select {
+	case <-l.stopped:
+		// the undone messages are left unmarked
+		return
+	default:
+	}
+
+	// pause while redis is unhealthy
+	if !l.s.breaker.wait(l.session.Context()) {
+		l.stop()
+		return
+	}
+
+	msgs := make([]*sarama.ConsumerMessage, len(batch))
+	for i, lm := range batch {
+		msgs[i] = lm.msg
+	}
+
+	for i, done := range l.s.processBatch(l.session.Context(), msgs) {
+		if !done {
+			l.stop()
+			return
+		}
+		l.complete(batch[i])
+	}
+}
+
+// complete marks the contiguous done messages from the oldest inflight one
+func (l *claimLanes) complete(lm *laneMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lm.done = true
+
+	var last *laneMessage
+	for len(l.inflight) > 0 && l.inflight[0].done {
+		last = l.inflight[0]
+		l.inflight[0] = nil
+		l.inflight = l.inflight[1:]
+	}
+
+	if last != nil {
+		l.s.markMessage(l.session, last.msg)
+	}
+}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/config"
+)
+
+// TestLanesMultiThenSingle checks a single-key write after a multi writing the key stays
+// behind the multi, though the multi message is keyed by its first key. The proxy requires
+// the keys of a multi to share the slot even if redis is not a cluster, see TestValidateCrossSlot.
+func TestLanesMultiThenSingle(t *testing.T) {
+	s := &consumerService{conf: config.ConsumerConfig{Lanes: 8, BatchSize: 64}}
+	l := &claimLanes{s: s, lanes: make([]chan *laneMessage, s.conf.Lanes)}
+
+	// multi set {user:1}a {user:1}b, then set {user:1}b
+	multi := &sarama.ConsumerMessage{Key: []byte("{user:1}a"), Offset: 1}
+	single := &sarama.ConsumerMessage{Key: []byte("{user:1}b"), Offset: 2}
+
+	if got, want := l.laneOf(s.laneKey(single)), l.laneOf(s.laneKey(multi)); got != want {
+		t.Fatalf("lane of the single write = %v, want the lane of the multi %v", got, want)
+	}
+
+	lane := make(chan *laneMessage, 2)
+	lane <- &laneMessage{msg: multi}
+	lane <- &laneMessage{msg: single}
+
+	batch, carry, open := l.collect(lane, nil)
+	if !open || len(batch) != 1 || batch[0].msg != multi {
+		t.Fatalf("batch = %v, want the multi only", batch)
+	}
+	if carry == nil || carry.msg != single {
+		t.Fatalf("carry = %v, want the single write", carry)
+	}
+}
@@ -0,0 +1,138 @@
+package consumer
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// processBatch applies the messages in order, the consecutive plain commands in one round
+// trip to sink. The lane keys of the messages must be distinct, so that a failed message retried
+// after the pipeline never overtakes a later message of its key. It returns whether each
+// message is done, the messages after the first undone one are not done either.
+func (s *consumerService) processBatch(ctx context.Context, msgs []*sarama.ConsumerMessage) []bool {
+	done := make([]bool, len(msgs))
+
+	if len(msgs) == 1 {
+		done[0] = s.process(ctx, msgs[0])
+		return done
+	}
+
+	tasks := make([]*task, len(msgs))
+	for i, msg := range msgs {
+		t, ok := s.decode(ctx, msg)
+		if !ok {
+			return done
+		}
+		if t == nil {
+			done[i] = true
+			continue
+		}
+		tasks[i] = t
+	}
+
+	for i := 0; i < len(tasks); {
+		switch {
+		case tasks[i] == nil:
+			i++
+		case !pipelinable(tasks[i]):
+			s.tokenBucket.Wait(1)
+			if done[i] = s.execute(tasks[i]); !done[i] {
+				return done
+			}
+			i++
+		default:
+			j := i + 1
+			for j < len(tasks) && (tasks[j] == nil || pipelinable(tasks[j])) {
+				j++
+			}
+			if !s.pipeline(tasks[i:j], done[i:j]) {
+				return done
+			}
+			i = j
+		}
+	}
+
+	return done
+}
+
+// pipelinable returns whether the task is a plain command, the idempotent and multi ones
+// are scripts or transactions applied alone.
+func pipelinable(t *task) bool {
+	return t.req.IdempotencyKey == "" && strings.ToLower(t.req.Cmd) != cmdMulti
+}
+
+// pipeline applies the tasks in one round trip with Sink.ApplyBatch. The failed ones are
+// retried alone unless the error is permanent. It returns false if stopping before all
+// tasks are done.
+func (s *consumerService) pipeline(tasks []*task, done []bool) bool {
+	var (
+		indexes = make([]int, 0, len(tasks))
+		msgs    = make([]*sarama.ConsumerMessage, 0, len(tasks))
+		reqs    = make([]*proxy.Request, 0, len(tasks))
+	)
+	for i, t := range tasks {
+		if t != nil {
+			indexes = append(indexes, i)
+			msgs = append(msgs, t.msg)
+			reqs = append(reqs, t.req)
+		}
+	}
+
+	s.tokenBucket.Wait(int64(len(indexes)))
+
+	begin := time.Now()
+	duplicates, errs := s.sink.ApplyBatch(msgs, reqs)
+
+	// finish in offset order, as the lane marks them
+	var (
+		results   = make([]error, len(tasks))
+		transient int
+	)
+	for k, i := range indexes {
+		if tasks[i].stale = errs[k] == errStale; tasks[i].stale {
+			errs[k] = nil
+		}
+		if results[i] = errs[k]; results[i] != nil && !s.classifier.permanent(results[i]) {
+			transient++
+		}
+		tasks[i].duplicate = duplicates[k]
+	}
+	s.breaker.record(len(indexes), transient, time.Since(begin))
+
+	for i, t := range tasks {
+		if t == nil {
+			continue
+		}
+
+		t.attempts++
+		err := results[i]
+		if err == nil {
+			done[i] = s.finish(t, true)
+		} else {
+			t.err = err
+			permanent := s.classifier.permanent(err)
+			t.logger.Error("failed to proxy redis command in pipeline",
+				zap.String("command", t.req.Cmd),
+				zap.Error(err),
+				zap.Bool("permanent", permanent),
+				zap.Bool("will_retry", !permanent),
+			)
+			if permanent {
+				done[i] = s.finish(t, false)
+			} else {
+				done[i] = s.execute(t)
+			}
+		}
+
+		if !done[i] {
+			return false
+		}
+	}
+	return true
+}
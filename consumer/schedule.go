@@ -0,0 +1,337 @@
+package consumer
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stn81/kate/rdb"
+	"github.com/stn81/retry"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/common/schedule"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// The scheduled writes, with apply_at in the future, are parked in the delay topic instead
+// of blocking the lane behind them. The scheduler of each sink consumes the delay topic,
+// holds its parked messages in a timer queue, and applies them when due unless cancelled.
+// The delay topic offset is marked only up to the earliest pending message, so the messages
+// must be due within the retention of the topic, as bounded by max_schedule_ahead.
+
+// applyAt returns the time the request takes effect, the zero time for now
+func applyAt(req *proxy.Request) time.Time {
+	if req.ApplyAtMs <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, req.ApplyAtMs*int64(time.Millisecond))
+}
+
+// park produces the scheduled message to the delay topic. It retries until success or the
+// consumer is stopping, false is returned for the later, and the message should not be
+// marked then.
+func (s *consumerService) park(logger *zap.Logger, msg *sarama.ConsumerMessage, at time.Time) bool {
+	meta := &schedule.Meta{
+		Sink:      s.sink.Name(),
+		ApplyAt:   at,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:     s.conf.DelayTopic,
+		Value:     sarama.ByteEncoder(msg.Value),
+		Headers:   meta.Headers(),
+		Timestamp: msg.Timestamp,
+	}
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	strategy := &retry.ExponentialBackoffStrategy{
+		InitialDelay: time.Millisecond * 100,
+		MaxDelay:     time.Second * 5,
+	}
+	return retry.Do(s.ctx, strategy, func() bool {
+		if _, _, err := s.producer.SendMessage(message); err != nil {
+			logger.Error("failed to send message to delay topic",
+				zap.String("delay_topic", s.conf.DelayTopic),
+				zap.Error(err),
+			)
+			return false
+		}
+		return true
+	})
+}
+
+// scheduler applies the parked messages of the sink when due
+type scheduler struct {
+	s         *consumerService
+	client    sarama.ConsumerGroup
+	logger    *zap.Logger
+	mu        sync.Mutex
+	queue     scheduleQueue
+	offsets   map[int32]*offsetTracker
+	session   sarama.ConsumerGroupSession
+	wake      chan struct{}
+	done      chan struct{}
+	pending   prometheus.Gauge
+	cancelled prometheus.Counter
+}
+
+func newScheduler(s *consumerService) *scheduler {
+	labels := prometheus.Labels{"sink": s.sink.Name()}
+	logger := s.logger.With(zap.String("delay_topic", s.conf.DelayTopic))
+
+	client, err := sarama.NewConsumerGroupFromClient(s.conf.ConsumerGroup+".delay", s.kafka)
+	if err != nil {
+		logger.Fatal("failed to create delay consumer group", zap.Error(err))
+	}
+
+	return &scheduler{
+		s:      s,
+		client: client,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		pending: promauto.NewGauge(prometheus.GaugeOpts{
+			Name:        "consumer_scheduled_pending",
+			Help:        "The number of scheduled messages waiting to be applied",
+			ConstLabels: labels,
+		}),
+		cancelled: promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "consumer_scheduled_cancelled",
+			Help:        "The number of scheduled messages skipped for cancelled",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (sc *scheduler) serve() {
+	defer sc.s.wg.Done()
+
+	for {
+		if err := sc.client.Consume(sc.s.ctx, []string{sc.s.conf.DelayTopic}, sc); err != nil {
+			sc.logger.Fatal("failed to consume delay topic", zap.Error(err))
+		}
+		if sc.s.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (sc *scheduler) close() {
+	if err := sc.client.Close(); err != nil {
+		sc.logger.Error("failed to close delay consumer group", zap.Error(err))
+	}
+}
+
+// Setup starts applying the due messages of the session
+func (sc *scheduler) Setup(session sarama.ConsumerGroupSession) error {
+	sc.mu.Lock()
+	sc.queue = nil
+	sc.offsets = make(map[int32]*offsetTracker)
+	sc.session = session
+	sc.mu.Unlock()
+	sc.pending.Set(0)
+
+	sc.done = make(chan struct{})
+	go sc.run(session.Context())
+	return nil
+}
+
+// Cleanup waits the applying to stop, the messages left are consumed again by next session
+func (sc *scheduler) Cleanup(session sarama.ConsumerGroupSession) error {
+	<-sc.done
+	return nil
+}
+
+// ConsumeClaim queues the parked messages of the sink, the others are marked as done
+func (sc *scheduler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		meta := schedule.Parse(msg.Headers)
+
+		sc.mu.Lock()
+		tracker, ok := sc.offsets[msg.Partition]
+		if !ok {
+			tracker = &offsetTracker{done: make(map[int64]bool)}
+			sc.offsets[msg.Partition] = tracker
+		}
+		tracker.add(msg.Offset)
+		if meta.Sink == sc.s.sink.Name() {
+			heap.Push(&sc.queue, &scheduledMessage{msg: msg, meta: meta})
+			sc.pending.Inc()
+		}
+		sc.mu.Unlock()
+
+		if meta.Sink != sc.s.sink.Name() {
+			sc.complete(msg)
+			continue
+		}
+
+		select {
+		case sc.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run applies the queued messages when due, until ctx done
+func (sc *scheduler) run(ctx context.Context) {
+	defer close(sc.done)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		sc.mu.Lock()
+		var next *scheduledMessage
+		if len(sc.queue) > 0 {
+			next = sc.queue[0]
+		}
+		sc.mu.Unlock()
+
+		delay := time.Hour
+		if next != nil {
+			if delay = time.Until(next.meta.ApplyAt); delay <= 0 {
+				if !sc.apply(ctx, next) {
+					return
+				}
+				continue
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-sc.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// apply applies the due message unless cancelled, and returns false if stopping before done
+func (sc *scheduler) apply(ctx context.Context, item *scheduledMessage) bool {
+	id := item.meta.ID()
+
+	// never applied without knowing it is not cancelled
+	var cancelled int64
+	strategy := &retry.ExponentialBackoffStrategy{
+		InitialDelay: time.Millisecond * 100,
+		MaxDelay:     time.Second * 5,
+	}
+	checked := retry.Do(ctx, strategy, func() bool {
+		var err error
+		if cancelled, err = rdb.Get().Exists(schedule.CancelKey(item.meta.Sink, id)).Result(); err != nil {
+			sc.logger.Error("failed to check cancellation of scheduled message", zap.String("id", id), zap.Error(err))
+			return false
+		}
+		return true
+	})
+	if !checked {
+		return false
+	}
+
+	if cancelled > 0 {
+		sc.cancelled.Inc()
+		sc.logger.Info("scheduled message cancelled",
+			zap.String("id", id),
+			zap.String("key", string(item.msg.Key)),
+			zap.Time("apply_at", item.meta.ApplyAt),
+		)
+	} else if !sc.s.process(ctx, item.msg) {
+		return false
+	}
+
+	sc.mu.Lock()
+	heap.Remove(&sc.queue, item.index)
+	sc.mu.Unlock()
+	sc.pending.Dec()
+
+	sc.complete(item.msg)
+	return true
+}
+
+// complete marks the message done, and the delay topic offset up to the done prefix
+func (sc *scheduler) complete(msg *sarama.ConsumerMessage) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if offset, ok := sc.offsets[msg.Partition].complete(msg.Offset); ok {
+		sc.session.MarkOffset(msg.Topic, msg.Partition, offset+1, "")
+	}
+}
+
+// scheduledMessage is a parked message in the timer queue
+type scheduledMessage struct {
+	msg   *sarama.ConsumerMessage
+	meta  *schedule.Meta
+	index int
+}
+
+// scheduleQueue is a min-heap of the parked messages by apply time, then by offset
+type scheduleQueue []*scheduledMessage
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool {
+	if !q[i].meta.ApplyAt.Equal(q[j].meta.ApplyAt) {
+		return q[i].meta.ApplyAt.Before(q[j].meta.ApplyAt)
+	}
+	return q[i].msg.Offset < q[j].msg.Offset
+}
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	item := x.(*scheduledMessage)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+// offsetTracker tracks the claimed offsets of a partition, done out of order
+type offsetTracker struct {
+	offsets []int64
+	done    map[int64]bool
+}
+
+func (t *offsetTracker) add(offset int64) {
+	t.offsets = append(t.offsets, offset)
+}
+
+// complete marks the offset done, and returns the last one of the done prefix if advanced
+func (t *offsetTracker) complete(offset int64) (last int64, advanced bool) {
+	t.done[offset] = true
+	for len(t.offsets) > 0 && t.done[t.offsets[0]] {
+		last = t.offsets[0]
+		delete(t.done, last)
+		t.offsets = t.offsets[1:]
+		advanced = true
+	}
+	return last, advanced
+}
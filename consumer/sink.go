@@ -0,0 +1,45 @@
+package consumer
+
+import (
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// Sink is the target the consumer applies the requests to. Each sink is consumed by its
+// own consumer group, so that a slow sink does not hold back the others.
+type Sink interface {
+	// Name returns the name of sink
+	Name() string
+
+	// Start starts the sink
+	Start()
+
+	// Stop stops the sink
+	Stop()
+
+	// Apply applies the request in the message. duplicate is true if the request is
+	// skipped because it has been applied before, and errStale is returned if skipped
+	// because a newer version has been applied.
+	Apply(msg *sarama.ConsumerMessage, req *proxy.Request) (duplicate bool, err error)
+
+	// ApplyBatch applies the plain requests, neither idempotent nor multi, in one round
+	// trip, and returns the result of each as Apply does.
+	ApplyBatch(msgs []*sarama.ConsumerMessage, reqs []*proxy.Request) (duplicates []bool, errs []error)
+
+	// Checkpoints returns the checkpointed offsets of the partitions, indexed by slot,
+	// -1 for none.
+	Checkpoints(partitions []int32) (map[int32][]int64, error)
+
+	// Applied is called with the offset marked as applied
+	Applied(partition int32, offset int64)
+
+	// Probe checks the health of the sink. An overloadError is returned if the sink is
+	// reachable but should not take writes.
+	Probe() error
+}
+
+// overloadError tells the sink is overloaded
+type overloadError string
+
+func (e overloadError) Error() string { return string(e) }
@@ -0,0 +1,374 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/common/hashtag"
+	"github.com/stn81/nec/common/route"
+	"github.com/stn81/nec/common/watermark"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+const (
+	checkpointKeyPrefix = "nec:ckpt:"
+	checkpointLoadBatch = 1024
+)
+
+// redisSink applies the requests to a redis or redis cluster, or to the redis targets
+// routed by key if the routes are configured.
+type redisSink struct {
+	name    string
+	conf    config.ConsumerConfig
+	main    *redisTarget
+	targets []*redisTarget          // the distinct targets, main first
+	routes  map[string]*redisTarget // route name to target
+	table   *route.Table
+	applied *watermark.Publisher
+	logger  *zap.Logger
+}
+
+// redisTarget is a redis or redis cluster the sink applies to
+type redisTarget struct {
+	name    string
+	client  rdb.Client
+	cluster bool
+	own     bool // the client is created by sink, and closed on stop
+}
+
+// newRedisSink creates the redis sink, conf is the consumer config of the sink. The default
+// sink shares the global redis client, and applies to the routed targets as well.
+func newRedisSink(sinkConf *config.SinkConfig, conf config.ConsumerConfig, logger *zap.Logger) *redisSink {
+	r := &redisSink{
+		name:   sinkConf.Name,
+		conf:   conf,
+		logger: logger,
+	}
+
+	if sinkConf.Name == config.DefaultSink {
+		r.main = &redisTarget{name: sinkConf.Name, client: rdb.Get(), cluster: sinkConf.Redis.ClusterEnabled}
+	} else {
+		r.main = &redisTarget{name: sinkConf.Name, client: route.NewClient(sinkConf.Redis.Config), cluster: sinkConf.Redis.ClusterEnabled, own: true}
+	}
+	r.targets = []*redisTarget{r.main}
+
+	if table := route.Get(); sinkConf.Name == config.DefaultSink && table.Enabled() {
+		r.table = table
+		r.routes = make(map[string]*redisTarget)
+		for _, rule := range table.Rules() {
+			r.routes[rule.Name] = r.routeTarget(rule)
+		}
+	}
+
+	r.applied = watermark.NewPublisher(r.main.client, conf.ConsumerGroup, config.Kafka.Topic, conf.AppliedPublish, logger)
+	return r
+}
+
+// routeTarget returns the target of the route, the targets of same sink and db are shared
+func (r *redisSink) routeTarget(rule *route.Rule) *redisTarget {
+	name := rule.Target
+	if rule.DB >= 0 {
+		name += "/" + strconv.Itoa(rule.DB)
+	}
+	for _, target := range r.targets {
+		if target.name == name {
+			return target
+		}
+	}
+
+	var redisConf *config.RedisConfig
+	for _, sink := range config.Sinks {
+		if sink.Name == rule.Target {
+			redisConf = sink.Redis
+		}
+	}
+
+	// the routes are validated against the sinks on loading
+	rdbConf := *redisConf.Config
+	if rule.DB >= 0 {
+		rdbConf.DB = rule.DB
+	}
+
+	target := &redisTarget{
+		name:    name,
+		client:  route.NewClient(&rdbConf),
+		cluster: rdbConf.ClusterEnabled,
+		own:     true,
+	}
+	r.targets = append(r.targets, target)
+	return target
+}
+
+// target returns the target of the key, errNoRoute if no route matches
+func (r *redisSink) target(key string) (*redisTarget, error) {
+	if r.table == nil {
+		return r.main, nil
+	}
+	rule := r.table.Match(key)
+	if rule == nil {
+		return nil, errNoRoute
+	}
+	return r.routes[rule.Name], nil
+}
+
+// Name implements the `Sink.Name()` method
+func (r *redisSink) Name() string {
+	return r.name
+}
+
+// Start implements the `Sink.Start()` method
+func (r *redisSink) Start() {
+	if r.conf.CheckpointEnabled || r.conf.VersionEnabled {
+		for _, target := range r.targets {
+			r.loadScripts(target)
+		}
+	}
+	r.applied.Start()
+}
+
+// Stop implements the `Sink.Stop()` method
+func (r *redisSink) Stop() {
+	r.applied.Stop()
+	for _, target := range r.targets {
+		if !target.own {
+			continue
+		}
+		if err := target.client.Close(); err != nil {
+			r.logger.Error("failed to close redis client", zap.String("target", target.name), zap.Error(err))
+		}
+	}
+}
+
+// Applied implements the `Sink.Applied()` method, it publishes the applied offset
+func (r *redisSink) Applied(partition int32, offset int64) {
+	r.applied.Mark(partition, offset)
+}
+
+// ApplyBatch implements the `Sink.ApplyBatch()` method with a redis pipeline per target,
+// grouped by slot on redis cluster.
+func (r *redisSink) ApplyBatch(msgs []*sarama.ConsumerMessage, reqs []*proxy.Request) (duplicates []bool, errs []error) {
+	duplicates = make([]bool, len(msgs))
+	errs = make([]error, len(msgs))
+
+	var (
+		targets []*redisTarget
+		orders  = make(map[*redisTarget][]int)
+	)
+	for i, msg := range msgs {
+		target, err := r.target(string(msg.Key))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if _, ok := orders[target]; !ok {
+			targets = append(targets, target)
+		}
+		orders[target] = append(orders[target], i)
+	}
+
+	cmds := make([]*redis.Cmd, len(msgs))
+	versioned := make([]bool, len(msgs))
+	for _, target := range targets {
+		order := orders[target]
+		if target.cluster {
+			sort.SliceStable(order, func(a, b int) bool {
+				return hashtag.Slot(string(msgs[order[a]].Key)) < hashtag.Slot(string(msgs[order[b]].Key))
+			})
+		}
+
+		// the errors are checked per command below
+		_, _ = target.client.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, i := range order {
+				switch {
+				case r.versioned(msgs[i], reqs[i]):
+					versioned[i] = true
+					keys, argv := r.versionArgs(msgs[i], reqs[i])
+					cmds[i] = pipe.EvalSha(versionScript.Hash(), keys, argv...)
+				case r.checkpointing(msgs[i]):
+					keys, argv := r.scriptArgs(msgs[i], string(msgs[i].Key), 0, requestCommands(reqs[i]))
+					cmds[i] = pipe.EvalSha(applyScript.Hash(), keys, argv...)
+				default:
+					cmds[i] = pipe.Do(requestCommands(reqs[i])[0]...)
+				}
+			}
+			return nil
+		})
+	}
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		switch errs[i] = cmd.Err(); {
+		case errs[i] != nil:
+		case versioned[i]:
+			result, _ := cmd.Int64()
+			duplicates[i], errs[i] = versionResult(result)
+		case r.checkpointing(msgs[i]):
+			applied, _ := cmd.Int()
+			duplicates[i] = applied == 0
+		}
+	}
+	return duplicates, errs
+}
+
+// checkpointing returns whether the message is checkpointed with the commands, only the
+// messages of the write topic are. The scheduled ones from the delay topic are applied out of
+// offset order.
+func (r *redisSink) checkpointing(msg *sarama.ConsumerMessage) bool {
+	return r.conf.CheckpointEnabled && msg.Topic == config.Kafka.Topic
+}
+
+// checkpointKey returns the checkpoint key of the slot
+func (r *redisSink) checkpointKey(slot int) string {
+	return checkpointKeyPrefix + "{" + hashtag.SlotTag(slot) + "}:" + r.conf.ConsumerGroup + ":" + config.Kafka.Topic
+}
+
+// Checkpoints implements the `Sink.Checkpoints()` method. The checkpoints of a slot are
+// a hash of partition to offset. With the routes, a slot is checkpointed on each target
+// it is applied to, and the lowest checkpoint of the targets counts.
+func (r *redisSink) Checkpoints(partitions []int32) (map[int32][]int64, error) {
+	var checkpoints map[int32][]int64
+	for _, target := range r.targets {
+		loaded, err := r.loadCheckpoints(target, partitions)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoints == nil {
+			checkpoints = loaded
+			continue
+		}
+		for partition, offsets := range loaded {
+			for slot, offset := range offsets {
+				if offset < checkpoints[partition][slot] {
+					checkpoints[partition][slot] = offset
+				}
+			}
+		}
+	}
+	return checkpoints, nil
+}
+
+// loadCheckpoints loads the checkpoints of the partitions on the target
+func (r *redisSink) loadCheckpoints(target *redisTarget, partitions []int32) (map[int32][]int64, error) {
+	fields := make([]string, len(partitions))
+	checkpoints := make(map[int32][]int64, len(partitions))
+	for i, partition := range partitions {
+		fields[i] = strconv.FormatInt(int64(partition), 10)
+		offsets := make([]int64, hashtag.SlotNumber)
+		for slot := range offsets {
+			offsets[slot] = -1
+		}
+		checkpoints[partition] = offsets
+	}
+
+	for begin := 0; begin < hashtag.SlotNumber; begin += checkpointLoadBatch {
+		cmds := make([]*redis.SliceCmd, 0, checkpointLoadBatch)
+		_, err := target.client.Pipelined(func(pipe redis.Pipeliner) error {
+			for slot := begin; slot < begin+checkpointLoadBatch && slot < hashtag.SlotNumber; slot++ {
+				cmds = append(cmds, pipe.HMGet(r.checkpointKey(slot), fields...))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, cmd := range cmds {
+			for j, value := range cmd.Val() {
+				str, ok := value.(string)
+				if !ok {
+					continue
+				}
+				if offset, err := strconv.ParseInt(str, 10, 64); err == nil {
+					checkpoints[partitions[j]][begin+i] = offset
+				}
+			}
+		}
+	}
+
+	return checkpoints, nil
+}
+
+// loadScripts loads the scripts on the masters, so that the pipelined EVALSHA finds them.
+// The NOSCRIPT failure, say after a restart of redis, is retried alone with EVAL anyway.
+func (r *redisSink) loadScripts(target *redisTarget) {
+	load := func(client *redis.Client) error {
+		if err := applyScript.Load(client).Err(); err != nil {
+			return err
+		}
+		return versionScript.Load(client).Err()
+	}
+
+	var err error
+	switch client := target.client.(type) {
+	case *redis.ClusterClient:
+		err = client.ForEachMaster(load)
+	case *redis.Client:
+		err = load(client)
+	}
+	if err != nil {
+		r.logger.Warn("failed to load scripts", zap.String("target", target.name), zap.Error(err))
+	}
+}
+
+// Probe implements the `Sink.Probe()` method, it checks the memory usage of each master
+// of the targets
+func (r *redisSink) Probe() error {
+	check := func(client *redis.Client) error {
+		info, err := client.Info("memory").Result()
+		if err != nil {
+			return err
+		}
+		return r.checkMemory(client.Options().Addr, info)
+	}
+
+	for _, target := range r.targets {
+		var err error
+		switch client := target.client.(type) {
+		case *redis.ClusterClient:
+			err = client.ForEachMaster(check)
+		case *redis.Client:
+			err = check(client)
+		default:
+			err = target.client.Ping().Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMemory checks used_memory in the INFO memory reply against the thresholds
+func (r *redisSink) checkMemory(addr, info string) error {
+	var used, max int64
+	for _, line := range strings.Split(info, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "used_memory":
+			used, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "maxmemory":
+			max, _ = strconv.ParseInt(kv[1], 10, 64)
+		}
+	}
+
+	if r.conf.BreakerMaxUsedMemory > 0 && used >= r.conf.BreakerMaxUsedMemory {
+		return overloadError(fmt.Sprintf("%s used_memory %d", addr, used))
+	}
+	if r.conf.BreakerMemoryRatio > 0 && max > 0 && float64(used)/float64(max) >= r.conf.BreakerMemoryRatio {
+		return overloadError(fmt.Sprintf("%s used_memory %d of maxmemory %d", addr, used, max))
+	}
+	return nil
+}
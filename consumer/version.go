@@ -0,0 +1,174 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-redis/redis"
+
+	"github.com/stn81/nec/common/hashtag"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+const versionKeyPrefix = "nec:ver:"
+
+// errStale is returned by the sink if the write is skipped for an older version than the
+// stored one, the write is done then.
+var errStale = errors.New("stale version")
+
+// versionedCommands are the commands applied last-write-wins if the version check enabled
+var versionedCommands = map[string]bool{
+	"set":    true,
+	"setex":  true,
+	"psetex": true,
+	"hset":   true,
+	"hmset":  true,
+}
+
+// versionScript applies the command only if its version is newer than the stored one, of
+// the key for the string commands and of each field for the hash commands. The versions
+// are zero padded to compare as strings, as the lua numbers lose the precision of int64.
+// With an idempotency retention, the command is checked against the idempotency key too,
+// which is recorded for the stale command as well, as in applyScript.
+//
+// KEYS[1]: the key of the command
+// KEYS[2]: the version key, a hash of "*" or "f:<field>" to version
+// KEYS[3]: the checkpoint key, if with a checkpoint
+// KEYS[#KEYS]: the idempotency key, if with an idempotency retention
+// ARGV[1]: the version of the command
+// ARGV[2]: the retention seconds of the version key, 0 for none
+// ARGV[3]: the checkpoint field, empty for none
+// ARGV[4]: the offset of the command
+// ARGV[5]: the retention seconds of the idempotency key, 0 for none
+// ARGV[6:]: the command and its args
+//
+// It returns 1 if applied, 0 if below the checkpoint or duplicate, -1 if stale.
+var versionScript = redis.NewScript(`
+local checkpoint = ARGV[3] ~= ''
+if checkpoint then
+	local applied = redis.call('HGET', KEYS[3], ARGV[3])
+	if applied and tonumber(applied) >= tonumber(ARGV[4]) then
+		return 0
+	end
+end
+local idem = tonumber(ARGV[5])
+if idem > 0 and not redis.call('SET', KEYS[#KEYS], 1, 'NX', 'EX', idem) then
+	if checkpoint then
+		redis.call('HSET', KEYS[3], ARGV[3], ARGV[4])
+	end
+	return 0
+end
+local function failed(res)
+	if idem > 0 then
+		redis.call('DEL', KEYS[#KEYS])
+	end
+	return res
+end
+local function newer(field)
+	local stored = redis.call('HGET', KEYS[2], field)
+	return not stored or stored < ARGV[1]
+end
+local applied = false
+local cmd = string.lower(ARGV[6])
+if cmd == 'hset' or cmd == 'hmset' then
+	for i = 8, #ARGV - 1, 2 do
+		local field = 'f:' .. ARGV[i]
+		if newer(field) then
+			local res = redis.pcall('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+			if type(res) == 'table' and res.err then
+				return failed(res)
+			end
+			redis.call('HSET', KEYS[2], field, ARGV[1])
+			applied = true
+		end
+	end
+elseif newer('*') then
+	local res = redis.pcall(unpack(ARGV, 6))
+	if type(res) == 'table' and res.err then
+		return failed(res)
+	end
+	if res then
+		redis.call('HSET', KEYS[2], '*', ARGV[1])
+	end
+	applied = true
+end
+if applied and tonumber(ARGV[2]) > 0 then
+	redis.call('EXPIRE', KEYS[2], ARGV[2])
+end
+if checkpoint then
+	redis.call('HSET', KEYS[3], ARGV[3], ARGV[4])
+end
+if not applied then
+	return -1
+end
+return 1
+`)
+
+// versioned returns whether the request in the message is applied last-write-wins, with the
+// idempotency key checked in the same script
+func (r *redisSink) versioned(msg *sarama.ConsumerMessage, req *proxy.Request) bool {
+	if !r.conf.VersionEnabled || req.Version <= 0 || !versionedCommands[strings.ToLower(req.Cmd)] {
+		return false
+	}
+	_, ok := versionKey(string(msg.Key))
+	return ok
+}
+
+// applyVersioned applies the request with versionScript
+func (r *redisSink) applyVersioned(target *redisTarget, msg *sarama.ConsumerMessage, req *proxy.Request) (duplicate bool, err error) {
+	keys, argv := r.versionArgs(msg, req)
+	result, err := versionScript.Run(target.client, keys, argv...).Int64()
+	if err != nil {
+		return false, err
+	}
+	return versionResult(result)
+}
+
+// versionKey returns the version key of key, in the slot of key
+func versionKey(key string) (string, bool) {
+	tagged, ok := hashtag.Tagged(versionKeyPrefix, key)
+	if !ok {
+		return "", false
+	}
+	if hashtag.Key(key) != key {
+		// the keys sharing a hashtag have their own versions
+		tagged += ":" + key
+	}
+	return tagged, true
+}
+
+// versionArgs returns the keys and args of versionScript for the versioned request
+func (r *redisSink) versionArgs(msg *sarama.ConsumerMessage, req *proxy.Request) (keys []string, argv []interface{}) {
+	key := string(msg.Key)
+	verKey, _ := versionKey(key)
+
+	keys = []string{key, verKey}
+	argv = []interface{}{fmt.Sprintf("%020d", req.Version), int64(r.conf.VersionRetention.Seconds()), "", msg.Offset, 0}
+
+	if r.checkpointing(msg) {
+		keys = append(keys, r.checkpointKey(hashtag.Slot(key)))
+		argv[2] = msg.Partition
+	}
+
+	// the idempotency key shares the slot with the version key
+	if req.IdempotencyKey != "" {
+		idemKey, _ := idempotencyKey(key, req.IdempotencyKey)
+		keys = append(keys, idemKey)
+		argv[4] = int64(r.conf.IdemRetention.Seconds())
+	}
+
+	return keys, append(argv, requestCommands(req)[0]...)
+}
+
+// versionResult converts the result of versionScript
+func versionResult(result int64) (duplicate bool, err error) {
+	switch result {
+	case 0:
+		return true, nil
+	case -1:
+		return false, errStale
+	}
+	return false, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/stn81/kate"
+
+	"github.com/stn81/nec/common/health"
 )
 
 const (
@@ -22,7 +24,8 @@ type HealthCheckHandler struct {
 
 func (h *HealthCheckHandler) ServeHTTP(ctx context.Context, w kate.ResponseWriter, r *kate.Request) {
 	status := &HealthStatus{
-		Status: StatusOK,
+		Status:    StatusOK,
+		SubStatus: health.Status(),
 	}
 	WriteJSON(w, status)
 }
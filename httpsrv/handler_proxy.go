@@ -0,0 +1,205 @@
+package httpsrv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/stn81/kate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/stn81/nec/proto/proxy"
+	"github.com/stn81/nec/proxysrv"
+)
+
+const encodingBase64 = "base64"
+
+// ErrInvalidRequest indicates the request body is malformed
+var ErrInvalidRequest = NewError(int(proxy.Error_INVALID_ARGUMENT), "invalid request")
+
+// DoHandler proxies a write to kafka as the grpc Proxy.Do
+type DoHandler struct {
+	BaseHandler
+}
+
+// BatchHandler proxies the writes to kafka as the grpc Proxy.DoBatch
+type BatchHandler struct {
+	BaseHandler
+}
+
+// doRequest is the json of proxy.Request. The args are utf-8 strings, or base64 encoded
+// bytes if encoding is "base64".
+type doRequest struct {
+	Cmd            string       `json:"cmd"`
+	Args           []string     `json:"args"`
+	Encoding       string       `json:"encoding,omitempty"`
+	IdempotencyKey string       `json:"idempotency_key,omitempty"`
+	Multi          []*doRequest `json:"multi,omitempty"`
+	DeadlineMs     int64        `json:"deadline_ms,omitempty"`
+	Version        int64        `json:"version,omitempty"`
+	ApplyAtMs      int64        `json:"apply_at_ms,omitempty"`
+}
+
+type batchRequest struct {
+	Requests []*doRequest `json:"requests"`
+}
+
+// doResponse is the json of proxy.Response
+type doResponse struct {
+	ErrNO     int           `json:"errno"`
+	ErrMsg    string        `json:"errmsg"`
+	Partition int32         `json:"partition"`
+	Offset    int64         `json:"offset"`
+	Parts     []*doResponse `json:"parts,omitempty"`
+}
+
+type batchResponse struct {
+	Responses []*doResponse `json:"responses"`
+}
+
+func (h *DoHandler) ServeHTTP(ctx context.Context, w kate.ResponseWriter, r *kate.Request) {
+	body := &doRequest{}
+	if err := json.Unmarshal(r.RawBody, body); err != nil {
+		ErrorStatus(ctx, w, http.StatusBadRequest, ErrInvalidRequest)
+		return
+	}
+
+	req, err := body.toProto()
+	if err != nil {
+		ErrorStatus(ctx, w, http.StatusBadRequest, NewError(int(proxy.Error_INVALID_ARGUMENT), err.Error()))
+		return
+	}
+
+	resp, err := proxysrv.Do(ctx, req)
+	if err != nil {
+		grpcError(ctx, w, err)
+		return
+	}
+
+	data := newDoResponse(resp)
+	result := &Result{ErrNO: data.ErrNO, ErrMsg: data.ErrMsg, Data: data}
+	WriteJSONStatus(w, errnoStatus(resp.Errno), result)
+}
+
+func (h *BatchHandler) ServeHTTP(ctx context.Context, w kate.ResponseWriter, r *kate.Request) {
+	body := &batchRequest{}
+	if err := json.Unmarshal(r.RawBody, body); err != nil {
+		ErrorStatus(ctx, w, http.StatusBadRequest, ErrInvalidRequest)
+		return
+	}
+
+	batch := &proxy.BatchRequest{Requests: make([]*proxy.Request, len(body.Requests))}
+	for i, item := range body.Requests {
+		req, err := item.toProto()
+		if err != nil {
+			ErrorStatus(ctx, w, http.StatusBadRequest, NewError(int(proxy.Error_INVALID_ARGUMENT), err.Error()))
+			return
+		}
+		batch.Requests[i] = req
+	}
+
+	resp, err := proxysrv.DoBatch(ctx, batch)
+	if err != nil {
+		grpcError(ctx, w, err)
+		return
+	}
+
+	// the failures of the items are in their own responses
+	data := &batchResponse{Responses: make([]*doResponse, len(resp.Responses))}
+	for i, itemResp := range resp.Responses {
+		data.Responses[i] = newDoResponse(itemResp)
+	}
+	h.OKData(ctx, w, data)
+}
+
+// grpcError writes out the grpc error of proxy
+func grpcError(ctx context.Context, w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		ErrorStatus(ctx, w, http.StatusBadRequest, NewError(int(proxy.Error_INVALID_ARGUMENT), st.Message()))
+	case codes.Unavailable:
+		ErrorStatus(ctx, w, http.StatusServiceUnavailable, NewError(ErrServerInternal.Code(), st.Message()))
+	default:
+		ErrorStatus(ctx, w, http.StatusInternalServerError, NewError(ErrServerInternal.Code(), st.Message()))
+	}
+}
+
+// errnoStatus maps the errno of proxy to the http status code
+func errnoStatus(errno proxy.Error) int {
+	switch errno {
+	case proxy.Error_OK, proxy.Error_COALESCED:
+		return http.StatusOK
+	case proxy.Error_SPOOLED:
+		return http.StatusAccepted
+	case proxy.Error_RATELIMIT:
+		return http.StatusTooManyRequests
+	case proxy.Error_SIZE_TOO_LARGE:
+		return http.StatusRequestEntityTooLarge
+	case proxy.Error_INVALID_ARGUMENT, proxy.Error_CROSSSLOT:
+		return http.StatusBadRequest
+	case proxy.Error_KAFKA_ERROR:
+		return http.StatusServiceUnavailable
+	case proxy.Error_TIMEOUT:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// toProto converts the json request, decoding the args
+func (req *doRequest) toProto() (*proxy.Request, error) {
+	if req.Encoding != "" && req.Encoding != encodingBase64 {
+		return nil, fmt.Errorf("unknown encoding: %q", req.Encoding)
+	}
+
+	pb := &proxy.Request{
+		Cmd:            req.Cmd,
+		Args:           make([][]byte, len(req.Args)),
+		IdempotencyKey: req.IdempotencyKey,
+		DeadlineMs:     req.DeadlineMs,
+		Version:        req.Version,
+		ApplyAtMs:      req.ApplyAtMs,
+	}
+
+	for i, arg := range req.Args {
+		if req.Encoding != encodingBase64 {
+			pb.Args[i] = []byte(arg)
+			continue
+		}
+
+		b, err := base64.StdEncoding.DecodeString(arg)
+		if err != nil {
+			return nil, err
+		}
+		pb.Args[i] = b
+	}
+
+	for _, sub := range req.Multi {
+		subPb, err := sub.toProto()
+		if err != nil {
+			return nil, err
+		}
+		pb.Multi = append(pb.Multi, subPb)
+	}
+	return pb, nil
+}
+
+func newDoResponse(resp *proxy.Response) *doResponse {
+	data := &doResponse{
+		ErrNO:     int(resp.Errno),
+		ErrMsg:    resp.Message,
+		Partition: resp.Partition,
+		Offset:    resp.Offset,
+	}
+	if data.ErrMsg == "" {
+		data.ErrMsg = ErrSuccess.Error()
+	}
+	for _, part := range resp.Parts {
+		data.Parts = append(data.Parts, newDoResponse(part))
+	}
+	return data
+}
@@ -0,0 +1,40 @@
+package httpsrv
+
+import (
+	"testing"
+)
+
+func TestToProtoEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		arg      string
+		want     string
+		err      bool
+	}{
+		{encoding: "", arg: "dg==", want: "dg=="},
+		{encoding: "base64", arg: "dg==", want: "v"},
+		{encoding: "base64", arg: "!", err: true},
+		{encoding: "b64", arg: "dg==", err: true},
+		{encoding: "hex", arg: "76", err: true},
+	}
+
+	for _, tt := range tests {
+		req := &doRequest{Cmd: "set", Args: []string{tt.arg}, Encoding: tt.encoding}
+
+		pb, err := req.toProto()
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("toProto(%q, %q) succeeded, want error", tt.encoding, tt.arg)
+		case !tt.err && err != nil:
+			t.Errorf("toProto(%q, %q) = %v", tt.encoding, tt.arg, err)
+		case !tt.err && string(pb.Args[0]) != tt.want:
+			t.Errorf("toProto(%q, %q) = %q, want %q", tt.encoding, tt.arg, pb.Args[0], tt.want)
+		}
+	}
+
+	// the grouped commands are checked too
+	req := &doRequest{Cmd: "multi", Multi: []*doRequest{{Cmd: "set", Args: []string{"k", "v"}, Encoding: "b64"}}}
+	if _, err := req.toProto(); err == nil {
+		t.Error("toProto of the grouped command with unknown encoding succeeded, want error")
+	}
+}
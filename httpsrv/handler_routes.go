@@ -0,0 +1,27 @@
+package httpsrv
+
+import (
+	"context"
+
+	"github.com/stn81/kate"
+
+	"github.com/stn81/nec/common/route"
+)
+
+// RoutesHandler shows the active routing table
+type RoutesHandler struct {
+	BaseHandler
+}
+
+type routesData struct {
+	Enabled bool          `json:"enabled"`
+	Rules   []*route.Rule `json:"rules"`
+}
+
+func (h *RoutesHandler) ServeHTTP(ctx context.Context, w kate.ResponseWriter, r *kate.Request) {
+	table := route.Get()
+	h.OKData(ctx, w, &routesData{
+		Enabled: table.Enabled(),
+		Rules:   table.Rules(),
+	})
+}
@@ -11,6 +11,11 @@ import (
 
 // Error writes out an error response
 func Error(ctx context.Context, w http.ResponseWriter, err interface{}) {
+	ErrorStatus(ctx, w, http.StatusOK, err)
+}
+
+// ErrorStatus writes out an error response with the http status code
+func ErrorStatus(ctx context.Context, w http.ResponseWriter, code int, err interface{}) {
 	errInfo, ok := err.(ErrorInfo)
 	if !ok {
 		errInfo = ErrServerInternal
@@ -25,7 +30,7 @@ func Error(ctx context.Context, w http.ResponseWriter, err interface{}) {
 		result.Data = errInfoWithData.Data()
 	}
 
-	if err := WriteJSON(w, result); err != nil {
+	if err := WriteJSONStatus(w, code, result); err != nil {
 		ctxzap.Extract(ctx).Error("write json response", zap.Error(err))
 	}
 }
@@ -55,11 +60,17 @@ func EncodeJSON(v interface{}) ([]byte, error) {
 
 // WriteJSON writes out an object which is serialized as json.
 func WriteJSON(w http.ResponseWriter, v interface{}) error {
+	return WriteJSONStatus(w, http.StatusOK, v)
+}
+
+// WriteJSONStatus writes out an object which is serialized as json with the http status code.
+func WriteJSONStatus(w http.ResponseWriter, code int, v interface{}) error {
 	b, err := EncodeJSON(v)
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", MIMEApplicationJSONCharsetUTF8)
+	w.WriteHeader(code)
 	if _, err = w.Write(b); err != nil {
 		return err
 	}
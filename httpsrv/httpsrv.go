@@ -67,6 +67,9 @@ func (s *httpService) start() {
 	router.SetMaxBodyBytes(s.conf.MaxBodyBytes)
 	router.Handle("/ping", &PingHandler{})
 	router.GET("/hc", c.Then(&HealthCheckHandler{}))
+	router.GET("/routes", c.Then(&RoutesHandler{}))
+	router.POST("/v1/do", c.Then(&DoHandler{}))
+	router.POST("/v1/batch", c.Then(&BatchHandler{}))
 	router.StdHandle("/metrics", promhttp.Handler())
 
 	// 生成一个http.Server对象
@@ -27,21 +27,42 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type Error int32
 
 const (
-	Error_OK             Error = 0
-	Error_RATELIMIT      Error = 1001
-	Error_SIZE_TOO_LARGE Error = 1002
+	Error_OK               Error = 0
+	Error_RATELIMIT        Error = 1001
+	Error_SIZE_TOO_LARGE   Error = 1002
+	Error_INVALID_ARGUMENT Error = 1003
+	Error_KAFKA_ERROR      Error = 1004
+	Error_TIMEOUT          Error = 1005
+	Error_CROSSSLOT        Error = 1006
+	// superseded by a later write of the key or field in the coalescing window
+	Error_COALESCED Error = 1007
+	// kafka unavailable, the write is spooled on the proxy disk and will be produced later,
+	// no partition/offset is returned
+	Error_SPOOLED Error = 1008
 )
 
 var Error_name = map[int32]string{
 	0:    "OK",
 	1001: "RATELIMIT",
 	1002: "SIZE_TOO_LARGE",
+	1003: "INVALID_ARGUMENT",
+	1004: "KAFKA_ERROR",
+	1005: "TIMEOUT",
+	1006: "CROSSSLOT",
+	1007: "COALESCED",
+	1008: "SPOOLED",
 }
 
 var Error_value = map[string]int32{
-	"OK":             0,
-	"RATELIMIT":      1001,
-	"SIZE_TOO_LARGE": 1002,
+	"OK":               0,
+	"RATELIMIT":        1001,
+	"SIZE_TOO_LARGE":   1002,
+	"INVALID_ARGUMENT": 1003,
+	"KAFKA_ERROR":      1004,
+	"TIMEOUT":          1005,
+	"CROSSSLOT":        1006,
+	"COALESCED":        1007,
+	"SPOOLED":          1008,
 }
 
 func (x Error) String() string {
@@ -53,8 +74,20 @@ func (Error) EnumDescriptor() ([]byte, []int) {
 }
 
 type Request struct {
-	Cmd                  string   `protobuf:"bytes,1,opt,name=cmd,proto3" json:"cmd,omitempty"`
-	Args                 [][]byte `protobuf:"bytes,2,rep,name=args,proto3" json:"args,omitempty"`
+	Cmd            string   `protobuf:"bytes,1,opt,name=cmd,proto3" json:"cmd,omitempty"`
+	Args           [][]byte `protobuf:"bytes,2,rep,name=args,proto3" json:"args,omitempty"`
+	IdempotencyKey string   `protobuf:"bytes,3,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	// the grouped commands if cmd is "multi", applied atomically
+	Multi []*Request `protobuf:"bytes,4,rep,name=multi,proto3" json:"multi,omitempty"`
+	// the unix time in ms after which the consumer drops the write as expired, 0 for none
+	DeadlineMs int64 `protobuf:"varint,5,opt,name=deadline_ms,json=deadlineMs,proto3" json:"deadline_ms,omitempty"`
+	// the last-write-wins version, stamped by proxy if 0. The stamped version is the unix
+	// time in ms shifted left by 20 bits plus a sequence, a client version must be in scale.
+	Version int64 `protobuf:"varint,6,opt,name=version,proto3" json:"version,omitempty"`
+	// the unix time in ms at which the write takes effect, 0 for now
+	ApplyAtMs int64 `protobuf:"varint,7,opt,name=apply_at_ms,json=applyAtMs,proto3" json:"apply_at_ms,omitempty"`
+	// the number of requests summed into the aggregated increment by proxy, 0 if not aggregated
+	Merged               int32    `protobuf:"varint,8,opt,name=merged,proto3" json:"merged,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -99,12 +132,58 @@ func (m *Request) GetArgs() [][]byte {
 	return nil
 }
 
+func (m *Request) GetIdempotencyKey() string {
+	if m != nil {
+		return m.IdempotencyKey
+	}
+	return ""
+}
+
+func (m *Request) GetMulti() []*Request {
+	if m != nil {
+		return m.Multi
+	}
+	return nil
+}
+
+func (m *Request) GetDeadlineMs() int64 {
+	if m != nil {
+		return m.DeadlineMs
+	}
+	return 0
+}
+
+func (m *Request) GetVersion() int64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Request) GetApplyAtMs() int64 {
+	if m != nil {
+		return m.ApplyAtMs
+	}
+	return 0
+}
+
+func (m *Request) GetMerged() int32 {
+	if m != nil {
+		return m.Merged
+	}
+	return 0
+}
+
 type Response struct {
-	Errno                Error    `protobuf:"varint,1,opt,name=errno,proto3,enum=proxy.Error" json:"errno,omitempty"`
-	Message              string   `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Errno     Error  `protobuf:"varint,1,opt,name=errno,proto3,enum=proxy.Error" json:"errno,omitempty"`
+	Message   string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Partition int32  `protobuf:"varint,3,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offset    int64  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`
+	// the responses of each key or slot if a multi-key write is split
+	Parts                []*Response `protobuf:"bytes,5,rep,name=parts,proto3" json:"parts,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
 }
 
 func (m *Response) Reset()         { *m = Response{} }
@@ -146,32 +225,587 @@ func (m *Response) GetMessage() string {
 	return ""
 }
 
+func (m *Response) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *Response) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *Response) GetParts() []*Response {
+	if m != nil {
+		return m.Parts
+	}
+	return nil
+}
+
+type BatchRequest struct {
+	Requests             []*Request `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *BatchRequest) Reset()         { *m = BatchRequest{} }
+func (m *BatchRequest) String() string { return proto.CompactTextString(m) }
+func (*BatchRequest) ProtoMessage()    {}
+func (*BatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{2}
+}
+
+func (m *BatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchRequest.Unmarshal(m, b)
+}
+func (m *BatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchRequest.Marshal(b, m, deterministic)
+}
+func (m *BatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchRequest.Merge(m, src)
+}
+func (m *BatchRequest) XXX_Size() int {
+	return xxx_messageInfo_BatchRequest.Size(m)
+}
+func (m *BatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchRequest proto.InternalMessageInfo
+
+func (m *BatchRequest) GetRequests() []*Request {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+type BatchResponse struct {
+	Responses            []*Response `protobuf:"bytes,1,rep,name=responses,proto3" json:"responses,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *BatchResponse) Reset()         { *m = BatchResponse{} }
+func (m *BatchResponse) String() string { return proto.CompactTextString(m) }
+func (*BatchResponse) ProtoMessage()    {}
+func (*BatchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{3}
+}
+
+func (m *BatchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BatchResponse.Unmarshal(m, b)
+}
+func (m *BatchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BatchResponse.Marshal(b, m, deterministic)
+}
+func (m *BatchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BatchResponse.Merge(m, src)
+}
+func (m *BatchResponse) XXX_Size() int {
+	return xxx_messageInfo_BatchResponse.Size(m)
+}
+func (m *BatchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BatchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BatchResponse proto.InternalMessageInfo
+
+func (m *BatchResponse) GetResponses() []*Response {
+	if m != nil {
+		return m.Responses
+	}
+	return nil
+}
+
+type WaitAppliedRequest struct {
+	Partition            int32    `protobuf:"varint,1,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offset               int64    `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	TimeoutMs            int64    `protobuf:"varint,3,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WaitAppliedRequest) Reset()         { *m = WaitAppliedRequest{} }
+func (m *WaitAppliedRequest) String() string { return proto.CompactTextString(m) }
+func (*WaitAppliedRequest) ProtoMessage()    {}
+func (*WaitAppliedRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{4}
+}
+
+func (m *WaitAppliedRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WaitAppliedRequest.Unmarshal(m, b)
+}
+func (m *WaitAppliedRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WaitAppliedRequest.Marshal(b, m, deterministic)
+}
+func (m *WaitAppliedRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WaitAppliedRequest.Merge(m, src)
+}
+func (m *WaitAppliedRequest) XXX_Size() int {
+	return xxx_messageInfo_WaitAppliedRequest.Size(m)
+}
+func (m *WaitAppliedRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WaitAppliedRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WaitAppliedRequest proto.InternalMessageInfo
+
+func (m *WaitAppliedRequest) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *WaitAppliedRequest) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *WaitAppliedRequest) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type WaitAppliedResponse struct {
+	Errno                Error    `protobuf:"varint,1,opt,name=errno,proto3,enum=proxy.Error" json:"errno,omitempty"`
+	Message              string   `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	AppliedOffset        int64    `protobuf:"varint,3,opt,name=applied_offset,json=appliedOffset,proto3" json:"applied_offset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WaitAppliedResponse) Reset()         { *m = WaitAppliedResponse{} }
+func (m *WaitAppliedResponse) String() string { return proto.CompactTextString(m) }
+func (*WaitAppliedResponse) ProtoMessage()    {}
+func (*WaitAppliedResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{5}
+}
+
+func (m *WaitAppliedResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WaitAppliedResponse.Unmarshal(m, b)
+}
+func (m *WaitAppliedResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WaitAppliedResponse.Marshal(b, m, deterministic)
+}
+func (m *WaitAppliedResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WaitAppliedResponse.Merge(m, src)
+}
+func (m *WaitAppliedResponse) XXX_Size() int {
+	return xxx_messageInfo_WaitAppliedResponse.Size(m)
+}
+func (m *WaitAppliedResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_WaitAppliedResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WaitAppliedResponse proto.InternalMessageInfo
+
+func (m *WaitAppliedResponse) GetErrno() Error {
+	if m != nil {
+		return m.Errno
+	}
+	return Error_OK
+}
+
+func (m *WaitAppliedResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *WaitAppliedResponse) GetAppliedOffset() int64 {
+	if m != nil {
+		return m.AppliedOffset
+	}
+	return 0
+}
+
+type GetRequest struct {
+	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	WaitPending          bool     `protobuf:"varint,2,opt,name=wait_pending,json=waitPending,proto3" json:"wait_pending,omitempty"`
+	TimeoutMs            int64    `protobuf:"varint,3,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetRequest) Reset()         { *m = GetRequest{} }
+func (m *GetRequest) String() string { return proto.CompactTextString(m) }
+func (*GetRequest) ProtoMessage()    {}
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{6}
+}
+
+func (m *GetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetRequest.Unmarshal(m, b)
+}
+func (m *GetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetRequest.Marshal(b, m, deterministic)
+}
+func (m *GetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetRequest.Merge(m, src)
+}
+func (m *GetRequest) XXX_Size() int {
+	return xxx_messageInfo_GetRequest.Size(m)
+}
+func (m *GetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetRequest proto.InternalMessageInfo
+
+func (m *GetRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *GetRequest) GetWaitPending() bool {
+	if m != nil {
+		return m.WaitPending
+	}
+	return false
+}
+
+func (m *GetRequest) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type HGetRequest struct {
+	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Field                []byte   `protobuf:"bytes,2,opt,name=field,proto3" json:"field,omitempty"`
+	WaitPending          bool     `protobuf:"varint,3,opt,name=wait_pending,json=waitPending,proto3" json:"wait_pending,omitempty"`
+	TimeoutMs            int64    `protobuf:"varint,4,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HGetRequest) Reset()         { *m = HGetRequest{} }
+func (m *HGetRequest) String() string { return proto.CompactTextString(m) }
+func (*HGetRequest) ProtoMessage()    {}
+func (*HGetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{7}
+}
+
+func (m *HGetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HGetRequest.Unmarshal(m, b)
+}
+func (m *HGetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HGetRequest.Marshal(b, m, deterministic)
+}
+func (m *HGetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HGetRequest.Merge(m, src)
+}
+func (m *HGetRequest) XXX_Size() int {
+	return xxx_messageInfo_HGetRequest.Size(m)
+}
+func (m *HGetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HGetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HGetRequest proto.InternalMessageInfo
+
+func (m *HGetRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *HGetRequest) GetField() []byte {
+	if m != nil {
+		return m.Field
+	}
+	return nil
+}
+
+func (m *HGetRequest) GetWaitPending() bool {
+	if m != nil {
+		return m.WaitPending
+	}
+	return false
+}
+
+func (m *HGetRequest) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+type ReadResponse struct {
+	Errno                Error    `protobuf:"varint,1,opt,name=errno,proto3,enum=proxy.Error" json:"errno,omitempty"`
+	Message              string   `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Value                []byte   `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Exists               bool     `protobuf:"varint,4,opt,name=exists,proto3" json:"exists,omitempty"`
+	Pending              bool     `protobuf:"varint,5,opt,name=pending,proto3" json:"pending,omitempty"`
+	PendingPartition     int32    `protobuf:"varint,6,opt,name=pending_partition,json=pendingPartition,proto3" json:"pending_partition,omitempty"`
+	PendingOffset        int64    `protobuf:"varint,7,opt,name=pending_offset,json=pendingOffset,proto3" json:"pending_offset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReadResponse) Reset()         { *m = ReadResponse{} }
+func (m *ReadResponse) String() string { return proto.CompactTextString(m) }
+func (*ReadResponse) ProtoMessage()    {}
+func (*ReadResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{8}
+}
+
+func (m *ReadResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReadResponse.Unmarshal(m, b)
+}
+func (m *ReadResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReadResponse.Marshal(b, m, deterministic)
+}
+func (m *ReadResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReadResponse.Merge(m, src)
+}
+func (m *ReadResponse) XXX_Size() int {
+	return xxx_messageInfo_ReadResponse.Size(m)
+}
+func (m *ReadResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReadResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReadResponse proto.InternalMessageInfo
+
+func (m *ReadResponse) GetErrno() Error {
+	if m != nil {
+		return m.Errno
+	}
+	return Error_OK
+}
+
+func (m *ReadResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *ReadResponse) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *ReadResponse) GetExists() bool {
+	if m != nil {
+		return m.Exists
+	}
+	return false
+}
+
+func (m *ReadResponse) GetPending() bool {
+	if m != nil {
+		return m.Pending
+	}
+	return false
+}
+
+func (m *ReadResponse) GetPendingPartition() int32 {
+	if m != nil {
+		return m.PendingPartition
+	}
+	return 0
+}
+
+func (m *ReadResponse) GetPendingOffset() int64 {
+	if m != nil {
+		return m.PendingOffset
+	}
+	return 0
+}
+
+type IngestRequest struct {
+	Seq                  uint64   `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Request              *Request `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IngestRequest) Reset()         { *m = IngestRequest{} }
+func (m *IngestRequest) String() string { return proto.CompactTextString(m) }
+func (*IngestRequest) ProtoMessage()    {}
+func (*IngestRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{9}
+}
+
+func (m *IngestRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IngestRequest.Unmarshal(m, b)
+}
+func (m *IngestRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IngestRequest.Marshal(b, m, deterministic)
+}
+func (m *IngestRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IngestRequest.Merge(m, src)
+}
+func (m *IngestRequest) XXX_Size() int {
+	return xxx_messageInfo_IngestRequest.Size(m)
+}
+func (m *IngestRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IngestRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IngestRequest proto.InternalMessageInfo
+
+func (m *IngestRequest) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *IngestRequest) GetRequest() *Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+type IngestAck struct {
+	Seq                  uint64    `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Response             *Response `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *IngestAck) Reset()         { *m = IngestAck{} }
+func (m *IngestAck) String() string { return proto.CompactTextString(m) }
+func (*IngestAck) ProtoMessage()    {}
+func (*IngestAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fae95c745fc9dd75, []int{10}
+}
+
+func (m *IngestAck) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IngestAck.Unmarshal(m, b)
+}
+func (m *IngestAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IngestAck.Marshal(b, m, deterministic)
+}
+func (m *IngestAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IngestAck.Merge(m, src)
+}
+func (m *IngestAck) XXX_Size() int {
+	return xxx_messageInfo_IngestAck.Size(m)
+}
+func (m *IngestAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_IngestAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IngestAck proto.InternalMessageInfo
+
+func (m *IngestAck) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *IngestAck) GetResponse() *Response {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("proxy.Error", Error_name, Error_value)
 	proto.RegisterType((*Request)(nil), "proxy.Request")
 	proto.RegisterType((*Response)(nil), "proxy.Response")
+	proto.RegisterType((*BatchRequest)(nil), "proxy.BatchRequest")
+	proto.RegisterType((*BatchResponse)(nil), "proxy.BatchResponse")
+	proto.RegisterType((*WaitAppliedRequest)(nil), "proxy.WaitAppliedRequest")
+	proto.RegisterType((*WaitAppliedResponse)(nil), "proxy.WaitAppliedResponse")
+	proto.RegisterType((*GetRequest)(nil), "proxy.GetRequest")
+	proto.RegisterType((*HGetRequest)(nil), "proxy.HGetRequest")
+	proto.RegisterType((*ReadResponse)(nil), "proxy.ReadResponse")
+	proto.RegisterType((*IngestRequest)(nil), "proxy.IngestRequest")
+	proto.RegisterType((*IngestAck)(nil), "proxy.IngestAck")
 }
 
 func init() { proto.RegisterFile("proxy/proxy.proto", fileDescriptor_fae95c745fc9dd75) }
 
 var fileDescriptor_fae95c745fc9dd75 = []byte{
-	// 247 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x3c, 0x90, 0xc1, 0x4e, 0xc2, 0x40,
-	0x10, 0x86, 0xa1, 0x58, 0x2a, 0x13, 0x52, 0xeb, 0x78, 0xb0, 0x7a, 0x22, 0xbd, 0x48, 0x3c, 0x14,
-	0x83, 0xbe, 0x00, 0xc6, 0x46, 0x1b, 0x31, 0x35, 0x6b, 0x4f, 0x5e, 0x48, 0xc5, 0x09, 0xf1, 0x40,
-	0xa7, 0xcc, 0x6e, 0x13, 0x7d, 0x64, 0x7d, 0x0a, 0xc3, 0x6e, 0xf5, 0xb2, 0xf9, 0xff, 0xec, 0x3f,
-	0xf3, 0xcd, 0x0c, 0x1c, 0x37, 0xc2, 0x9f, 0x5f, 0x33, 0xfb, 0xa6, 0x8d, 0xb0, 0x61, 0xf4, 0xad,
-	0x49, 0x66, 0x10, 0x28, 0xda, 0xb5, 0xa4, 0x0d, 0x46, 0x30, 0x58, 0x6f, 0xdf, 0xe3, 0xfe, 0xa4,
-	0x3f, 0x1d, 0xa9, 0xbd, 0x44, 0x84, 0x83, 0x4a, 0x36, 0x3a, 0xf6, 0x26, 0x83, 0xe9, 0x58, 0x59,
-	0x9d, 0x3c, 0xc0, 0xa1, 0x22, 0xdd, 0x70, 0xad, 0x09, 0x13, 0xf0, 0x49, 0xa4, 0x66, 0x5b, 0x13,
-	0xce, 0xc7, 0xa9, 0x03, 0x64, 0x22, 0x2c, 0xca, 0x7d, 0x61, 0x0c, 0xc1, 0x96, 0xb4, 0xae, 0x36,
-	0x14, 0x7b, 0xb6, 0xf3, 0x9f, 0xbd, 0xbc, 0x01, 0xdf, 0x26, 0x71, 0x08, 0x5e, 0xf1, 0x18, 0xf5,
-	0x30, 0x84, 0x91, 0x5a, 0x94, 0xd9, 0x32, 0x7f, 0xca, 0xcb, 0xe8, 0x3b, 0xc0, 0x13, 0x08, 0x5f,
-	0xf2, 0xd7, 0x6c, 0x55, 0x16, 0xc5, 0x6a, 0xb9, 0x50, 0xf7, 0x59, 0xf4, 0x13, 0xcc, 0xaf, 0xc0,
-	0x7f, 0xde, 0x53, 0xf0, 0x02, 0xbc, 0x3b, 0xc6, 0xb0, 0x63, 0x76, 0x4b, 0x9c, 0x1f, 0xfd, 0x7b,
-	0x37, 0x63, 0xd2, 0xbb, 0x3d, 0x83, 0xd3, 0x9a, 0x4c, 0xba, 0x6b, 0x0d, 0xb7, 0xe6, 0xa3, 0xe2,
-	0xb4, 0xa6, 0xb5, 0x4b, 0xbd, 0x0d, 0xed, 0x2d, 0xae, 0x7f, 0x03, 0x00, 0x00, 0xff, 0xff, 0x06,
-	0x50, 0x13, 0xcd, 0x20, 0x01, 0x00, 0x00,
+	// 862 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0xd9, 0x6e, 0xdb, 0x46,
+	0x14, 0x35, 0x45, 0x51, 0x94, 0xae, 0x68, 0x85, 0x1e, 0xbb, 0x2d, 0x23, 0x74, 0x51, 0x89, 0x06,
+	0x11, 0x12, 0xd4, 0x69, 0x5d, 0xa0, 0x0f, 0x7d, 0x28, 0xc0, 0x44, 0x8c, 0xa3, 0x4a, 0x0a, 0x8d,
+	0x91, 0xd2, 0x02, 0x7d, 0x61, 0x59, 0x69, 0xac, 0x12, 0x96, 0x48, 0x9a, 0x33, 0x4a, 0xa2, 0xfe,
+	0x49, 0x3f, 0xa0, 0x7f, 0xd3, 0x0f, 0xe9, 0xbe, 0xfc, 0x41, 0x31, 0x0b, 0xb5, 0x58, 0x6e, 0xfd,
+	0xe2, 0x17, 0xe1, 0xde, 0x73, 0x97, 0x73, 0x17, 0xde, 0x11, 0x1c, 0x64, 0x79, 0xfa, 0x7a, 0xf9,
+	0x48, 0xfc, 0x1e, 0x67, 0x79, 0xca, 0x52, 0x64, 0x08, 0xc5, 0xfd, 0x47, 0x03, 0x13, 0x93, 0xcb,
+	0x05, 0xa1, 0x0c, 0xd9, 0xa0, 0x8f, 0xe7, 0x13, 0x47, 0x6b, 0x69, 0xed, 0x1a, 0xe6, 0x22, 0x42,
+	0x50, 0x8e, 0xf2, 0x29, 0x75, 0x4a, 0x2d, 0xbd, 0x6d, 0x61, 0x21, 0xa3, 0xfb, 0x70, 0x27, 0x9e,
+	0x90, 0x79, 0x96, 0x32, 0x92, 0x8c, 0x97, 0xe1, 0x05, 0x59, 0x3a, 0xba, 0x88, 0x68, 0x6c, 0xc0,
+	0x3d, 0xb2, 0x44, 0x1f, 0x80, 0x31, 0x5f, 0xcc, 0x58, 0xec, 0x94, 0x5b, 0x7a, 0xbb, 0x7e, 0xd2,
+	0x38, 0x96, 0xf4, 0x8a, 0x0d, 0x4b, 0x23, 0x7a, 0x0f, 0xea, 0x13, 0x12, 0x4d, 0x66, 0x71, 0x42,
+	0xc2, 0x39, 0x75, 0x8c, 0x96, 0xd6, 0xd6, 0x31, 0x14, 0xd0, 0x80, 0x22, 0x07, 0xcc, 0x97, 0x24,
+	0xa7, 0x71, 0x9a, 0x38, 0x15, 0x61, 0x2c, 0x54, 0xf4, 0x2e, 0xd4, 0xa3, 0x2c, 0x9b, 0x2d, 0xc3,
+	0x88, 0xf1, 0x50, 0x53, 0x58, 0x6b, 0x02, 0xf2, 0xd8, 0x80, 0xa2, 0x37, 0xa1, 0x32, 0x27, 0xf9,
+	0x94, 0x4c, 0x9c, 0x6a, 0x4b, 0x6b, 0x1b, 0x58, 0x69, 0xee, 0x8f, 0x1a, 0x54, 0x31, 0xa1, 0x59,
+	0x9a, 0x50, 0x82, 0x5c, 0x30, 0x48, 0x9e, 0x27, 0xa9, 0x68, 0xbb, 0x71, 0x62, 0xa9, 0x2a, 0xfd,
+	0x3c, 0x4f, 0x73, 0x2c, 0x4d, 0xbc, 0x84, 0x39, 0xa1, 0x34, 0x9a, 0x12, 0xa7, 0x24, 0x5a, 0x2d,
+	0x54, 0xf4, 0x36, 0xd4, 0xb2, 0x28, 0x67, 0x31, 0xe3, 0xe5, 0xe9, 0x82, 0x65, 0x0d, 0xf0, 0x02,
+	0xd2, 0xf3, 0x73, 0x4a, 0x98, 0x53, 0x16, 0xb5, 0x29, 0x0d, 0xdd, 0x03, 0x83, 0x3b, 0xf1, 0x6e,
+	0xf9, 0x64, 0xee, 0xac, 0x26, 0x23, 0x6b, 0xc2, 0xd2, 0xea, 0x7e, 0x06, 0xd6, 0xe3, 0x88, 0x8d,
+	0xbf, 0x2b, 0xf6, 0xf3, 0x00, 0xaa, 0xb9, 0x14, 0xa9, 0xa3, 0x5d, 0x3b, 0xd3, 0x95, 0xdd, 0xfd,
+	0x1c, 0xf6, 0x55, 0xac, 0xea, 0xf3, 0x43, 0xa8, 0xe5, 0x4a, 0x2e, 0xa2, 0x77, 0x78, 0xd7, 0x1e,
+	0x6e, 0x0c, 0xe8, 0xab, 0x28, 0x66, 0x5e, 0x96, 0xcd, 0x62, 0x32, 0x29, 0x2a, 0xd8, 0x6a, 0x57,
+	0xfb, 0xef, 0x76, 0x4b, 0x5b, 0xed, 0xbe, 0x03, 0xc0, 0xe2, 0x39, 0x49, 0x17, 0x62, 0x4d, 0xba,
+	0x5c, 0x93, 0x42, 0x06, 0xd4, 0xfd, 0x1e, 0x0e, 0xb7, 0xa8, 0x6e, 0x65, 0x31, 0xf7, 0xa0, 0x11,
+	0xc9, 0x84, 0xa1, 0xaa, 0x49, 0xf2, 0xee, 0x2b, 0x34, 0x10, 0xa0, 0xfb, 0x0d, 0xc0, 0x29, 0x61,
+	0x1b, 0x07, 0xc0, 0x3f, 0x67, 0x4e, 0x68, 0x61, 0x2e, 0xa2, 0xf7, 0xc1, 0x7a, 0x15, 0xc5, 0x2c,
+	0xcc, 0x48, 0x32, 0x89, 0x93, 0xa9, 0x60, 0xa9, 0xe2, 0x3a, 0xc7, 0xce, 0x24, 0x74, 0x53, 0x77,
+	0xaf, 0xa0, 0xfe, 0xec, 0x7f, 0x29, 0x8e, 0xc0, 0x38, 0x8f, 0xc9, 0x6c, 0x22, 0x72, 0x5b, 0x58,
+	0x2a, 0x3b, 0xc4, 0xfa, 0x4d, 0xc4, 0xe5, 0xab, 0xc4, 0x3f, 0x6b, 0x60, 0x61, 0x12, 0xdd, 0xd6,
+	0x40, 0x8f, 0xc0, 0x78, 0x19, 0xcd, 0x16, 0x44, 0x54, 0x62, 0x61, 0xa9, 0xf0, 0x95, 0x93, 0xd7,
+	0x31, 0x65, 0x92, 0xbf, 0x8a, 0x95, 0xc6, 0xf3, 0x14, 0x95, 0x1b, 0xc2, 0x50, 0xa8, 0xe8, 0x21,
+	0x1c, 0x28, 0x31, 0x5c, 0x7f, 0x4a, 0x15, 0xf1, 0x29, 0xd9, 0xca, 0x70, 0x56, 0xe0, 0x7c, 0x8b,
+	0x85, 0xb3, 0xda, 0xa2, 0x3c, 0xf2, 0x7d, 0x85, 0xaa, 0x2d, 0xf6, 0x60, 0xbf, 0x9b, 0x4c, 0x09,
+	0xdd, 0x9c, 0x32, 0x25, 0x97, 0xa2, 0xd1, 0x32, 0xe6, 0x22, 0x6a, 0x83, 0xa9, 0x6e, 0x43, 0x34,
+	0xb6, 0x7b, 0x3a, 0x85, 0xd9, 0xfd, 0x02, 0x6a, 0x32, 0x99, 0x37, 0xbe, 0xb8, 0x26, 0xd1, 0x43,
+	0x7e, 0x84, 0x72, 0xa2, 0x2a, 0xd3, 0xce, 0x19, 0xad, 0x1c, 0x1e, 0xfc, 0xa0, 0x81, 0x21, 0xe6,
+	0x8b, 0x2a, 0x50, 0x0a, 0x7a, 0xf6, 0x1e, 0x6a, 0x40, 0x0d, 0x7b, 0x23, 0xbf, 0xdf, 0x1d, 0x74,
+	0x47, 0xf6, 0x2f, 0x26, 0x3a, 0x84, 0xc6, 0xb0, 0xfb, 0xb5, 0x1f, 0x8e, 0x82, 0x20, 0xec, 0x7b,
+	0xf8, 0xd4, 0xb7, 0x7f, 0x35, 0xd1, 0x1b, 0x60, 0x77, 0x9f, 0x7f, 0xe9, 0xf5, 0xbb, 0x9d, 0xd0,
+	0xc3, 0xa7, 0x2f, 0x06, 0xfe, 0xf3, 0x91, 0xfd, 0x9b, 0x89, 0x6c, 0xa8, 0xf7, 0xbc, 0xa7, 0x3d,
+	0x2f, 0xf4, 0x31, 0x0e, 0xb0, 0xfd, 0xbb, 0x89, 0x2c, 0x30, 0x47, 0xdd, 0x81, 0x1f, 0xbc, 0x18,
+	0xd9, 0x7f, 0x98, 0x3c, 0xf7, 0x13, 0x1c, 0x0c, 0x87, 0xc3, 0x7e, 0x30, 0xb2, 0xff, 0x94, 0x7a,
+	0xe0, 0xf5, 0xfd, 0xe1, 0x13, 0xbf, 0x63, 0xff, 0x25, 0xbc, 0x87, 0x67, 0x41, 0xd0, 0xf7, 0x3b,
+	0xf6, 0xdf, 0xe6, 0xc9, 0x4f, 0x25, 0x30, 0xce, 0x78, 0xe1, 0xe8, 0x3e, 0x94, 0x3a, 0x29, 0xba,
+	0x32, 0x90, 0xe6, 0xd5, 0xb6, 0xdc, 0x3d, 0xf4, 0x29, 0x98, 0x9d, 0x54, 0x3c, 0x2b, 0xe8, 0x50,
+	0x59, 0x37, 0x1f, 0xa8, 0xe6, 0xd1, 0x36, 0xb8, 0x11, 0x57, 0x91, 0x23, 0x45, 0x85, 0xc7, 0xd6,
+	0xba, 0x9a, 0xf6, 0x16, 0xea, 0x8d, 0x2f, 0xdc, 0xbd, 0xb6, 0xf6, 0x91, 0x86, 0x9e, 0x42, 0x7d,
+	0xe3, 0x65, 0x40, 0x77, 0x95, 0xdb, 0xee, 0xc3, 0xd4, 0x6c, 0x5e, 0x67, 0x5a, 0xf1, 0x3f, 0x02,
+	0xfd, 0x94, 0x30, 0x74, 0xa0, 0x9c, 0xd6, 0xe7, 0xd8, 0x3c, 0x5c, 0x35, 0x19, 0x6d, 0x06, 0x7c,
+	0x0c, 0x65, 0x7e, 0xb4, 0x08, 0x29, 0xf3, 0xb3, 0x1b, 0x43, 0x1e, 0xdf, 0x85, 0xb7, 0x12, 0xc2,
+	0x8e, 0x2f, 0x17, 0x2c, 0x5d, 0xb0, 0x38, 0x4a, 0x8f, 0x13, 0x32, 0x96, 0x9e, 0xdf, 0x56, 0xc4,
+	0x3f, 0xee, 0x27, 0xff, 0x0e, 0x00, 0xaa, 0xc3, 0x4f, 0x2f, 0x86, 0x07, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -187,6 +821,11 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type ProxyClient interface {
 	Do(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	DoBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	Ingest(ctx context.Context, opts ...grpc.CallOption) (Proxy_IngestClient, error)
+	WaitApplied(ctx context.Context, in *WaitAppliedRequest, opts ...grpc.CallOption) (*WaitAppliedResponse, error)
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ReadResponse, error)
+	HGet(ctx context.Context, in *HGetRequest, opts ...grpc.CallOption) (*ReadResponse, error)
 }
 
 type proxyClient struct {
@@ -206,9 +845,81 @@ func (c *proxyClient) Do(ctx context.Context, in *Request, opts ...grpc.CallOpti
 	return out, nil
 }
 
+func (c *proxyClient) DoBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/proxy.Proxy/DoBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxyClient) Ingest(ctx context.Context, opts ...grpc.CallOption) (Proxy_IngestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[0], "/proxy.Proxy/Ingest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proxyIngestClient{stream}
+	return x, nil
+}
+
+type Proxy_IngestClient interface {
+	Send(*IngestRequest) error
+	Recv() (*IngestAck, error)
+	grpc.ClientStream
+}
+
+type proxyIngestClient struct {
+	grpc.ClientStream
+}
+
+func (x *proxyIngestClient) Send(m *IngestRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *proxyIngestClient) Recv() (*IngestAck, error) {
+	m := new(IngestAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *proxyClient) WaitApplied(ctx context.Context, in *WaitAppliedRequest, opts ...grpc.CallOption) (*WaitAppliedResponse, error) {
+	out := new(WaitAppliedResponse)
+	err := c.cc.Invoke(ctx, "/proxy.Proxy/WaitApplied", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxyClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ReadResponse, error) {
+	out := new(ReadResponse)
+	err := c.cc.Invoke(ctx, "/proxy.Proxy/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxyClient) HGet(ctx context.Context, in *HGetRequest, opts ...grpc.CallOption) (*ReadResponse, error) {
+	out := new(ReadResponse)
+	err := c.cc.Invoke(ctx, "/proxy.Proxy/HGet", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProxyServer is the server API for Proxy service.
 type ProxyServer interface {
 	Do(context.Context, *Request) (*Response, error)
+	DoBatch(context.Context, *BatchRequest) (*BatchResponse, error)
+	Ingest(Proxy_IngestServer) error
+	WaitApplied(context.Context, *WaitAppliedRequest) (*WaitAppliedResponse, error)
+	Get(context.Context, *GetRequest) (*ReadResponse, error)
+	HGet(context.Context, *HGetRequest) (*ReadResponse, error)
 }
 
 // UnimplementedProxyServer can be embedded to have forward compatible implementations.
@@ -218,6 +929,21 @@ type UnimplementedProxyServer struct {
 func (*UnimplementedProxyServer) Do(ctx context.Context, req *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Do not implemented")
 }
+func (*UnimplementedProxyServer) DoBatch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DoBatch not implemented")
+}
+func (*UnimplementedProxyServer) Ingest(srv Proxy_IngestServer) error {
+	return status.Errorf(codes.Unimplemented, "method Ingest not implemented")
+}
+func (*UnimplementedProxyServer) WaitApplied(ctx context.Context, req *WaitAppliedRequest) (*WaitAppliedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitApplied not implemented")
+}
+func (*UnimplementedProxyServer) Get(ctx context.Context, req *GetRequest) (*ReadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (*UnimplementedProxyServer) HGet(ctx context.Context, req *HGetRequest) (*ReadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HGet not implemented")
+}
 
 func RegisterProxyServer(s *grpc.Server, srv ProxyServer) {
 	s.RegisterService(&_Proxy_serviceDesc, srv)
@@ -241,6 +967,104 @@ func _Proxy_Do_Handler(srv interface{}, ctx context.Context, dec func(interface{
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Proxy_DoBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).DoBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proxy.Proxy/DoBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).DoBatch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxy_Ingest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProxyServer).Ingest(&proxyIngestServer{stream})
+}
+
+type Proxy_IngestServer interface {
+	Send(*IngestAck) error
+	Recv() (*IngestRequest, error)
+	grpc.ServerStream
+}
+
+type proxyIngestServer struct {
+	grpc.ServerStream
+}
+
+func (x *proxyIngestServer) Send(m *IngestAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *proxyIngestServer) Recv() (*IngestRequest, error) {
+	m := new(IngestRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Proxy_WaitApplied_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitAppliedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).WaitApplied(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proxy.Proxy/WaitApplied",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).WaitApplied(ctx, req.(*WaitAppliedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxy_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proxy.Proxy/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxy_HGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).HGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proxy.Proxy/HGet",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).HGet(ctx, req.(*HGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Proxy_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proxy.Proxy",
 	HandlerType: (*ProxyServer)(nil),
@@ -249,7 +1073,30 @@ var _Proxy_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Do",
 			Handler:    _Proxy_Do_Handler,
 		},
+		{
+			MethodName: "DoBatch",
+			Handler:    _Proxy_DoBatch_Handler,
+		},
+		{
+			MethodName: "WaitApplied",
+			Handler:    _Proxy_WaitApplied_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Proxy_Get_Handler,
+		},
+		{
+			MethodName: "HGet",
+			Handler:    _Proxy_HGet_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ingest",
+			Handler:       _Proxy_Ingest_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proxy/proxy.proto",
 }
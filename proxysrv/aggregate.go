@@ -0,0 +1,193 @@
+package proxysrv
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// aggregator sums the increments of a key, hash field or sorted set member in a window.
+// The first increment opens the window, and the sum is produced as one request when the
+// window closes. Every caller of the window gets the response of the produced one, so the
+// ack is as durable as without aggregating. Each window holds the writes of its key, see
+// holdSet.
+type aggregator struct {
+	window     time.Duration
+	cmds       map[string]bool
+	mu         sync.Mutex
+	windows    map[string]*aggregate
+	holds      *holdSet
+	aggregated prometheus.Counter
+	produced   prometheus.Counter
+}
+
+// aggregate is the sum of an open window, done once the sum is produced
+type aggregate struct {
+	*hold
+	cmd   string
+	key   []byte
+	field []byte // the field of hincrby, or the member of zincrby
+	delta int64
+	score float64 // the delta of zincrby
+	count int32
+	resp  *proxy.Response
+	err   error
+}
+
+// increment is an increment parsed from a request
+type increment struct {
+	key   []byte
+	field []byte
+	delta int64
+	score float64
+}
+
+func newAggregator(window time.Duration, cmds map[string]bool) *aggregator {
+	return &aggregator{
+		window:  window,
+		cmds:    cmds,
+		windows: make(map[string]*aggregate),
+		holds:   newHoldSet(),
+		aggregated: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "req_aggregated_total",
+			Help: "The number of requests summed into the aggregated increments by proxy",
+		}),
+		produced: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "req_aggregate_produced_total",
+			Help: "The number of aggregated increments produced by proxy",
+		}),
+	}
+}
+
+// parse parses the increment of the request, ok is false if not aggregatable
+func (a *aggregator) parse(req *proxy.Request, cmd string) (inc *increment, ok bool) {
+	if a.window <= 0 || !a.cmds[cmd] || req.IdempotencyKey != "" || req.ApplyAtMs > 0 || req.DeadlineMs > 0 {
+		return nil, false
+	}
+
+	var err error
+	switch {
+	case cmd == "incrby" && len(req.Args) == 2:
+		inc = &increment{key: req.Args[0]}
+		inc.delta, err = strconv.ParseInt(string(req.Args[1]), 10, 64)
+	case cmd == "hincrby" && len(req.Args) == 3:
+		inc = &increment{key: req.Args[0], field: req.Args[1]}
+		inc.delta, err = strconv.ParseInt(string(req.Args[2]), 10, 64)
+	case cmd == "zincrby" && len(req.Args) == 3:
+		inc = &increment{key: req.Args[0], field: req.Args[2]}
+		inc.score, err = strconv.ParseFloat(string(req.Args[1]), 64)
+	default:
+		return nil, false
+	}
+	// leave the malformed one to fail in redis as is, and the non-finite score not to poison
+	// the sum of the others
+	return inc, err == nil && !math.IsNaN(inc.score) && !math.IsInf(inc.score, 0)
+}
+
+// windowID returns the window the request joins, empty if not aggregatable
+func (a *aggregator) windowID(req *proxy.Request, cmd string) string {
+	inc, ok := a.parse(req, cmd)
+	if !ok {
+		return ""
+	}
+	return incrementID(cmd, inc)
+}
+
+func incrementID(cmd string, inc *increment) string {
+	return cmd + "\x00" + string(inc.key) + "\x00" + string(inc.field)
+}
+
+// add adds the increment to the sum, false is returned if the sum overflows
+func (agg *aggregate) add(inc *increment) bool {
+	if agg.cmd == "zincrby" {
+		score := agg.score + inc.score
+		if math.IsInf(score, 0) {
+			return false
+		}
+		agg.score = score
+		return true
+	}
+	if (inc.delta > 0 && agg.delta > math.MaxInt64-inc.delta) || (inc.delta < 0 && agg.delta < math.MinInt64-inc.delta) {
+		return false
+	}
+	agg.delta += inc.delta
+	return true
+}
+
+// request returns the request of the sum
+func (agg *aggregate) request() *proxy.Request {
+	req := &proxy.Request{Cmd: agg.cmd, Merged: agg.count}
+	switch agg.cmd {
+	case "incrby":
+		req.Args = [][]byte{agg.key, []byte(strconv.FormatInt(agg.delta, 10))}
+	case "hincrby":
+		req.Args = [][]byte{agg.key, agg.field, []byte(strconv.FormatInt(agg.delta, 10))}
+	case "zincrby":
+		req.Args = [][]byte{agg.key, []byte(strconv.FormatFloat(agg.score, 'f', -1, 64)), agg.field}
+	}
+	return req
+}
+
+// aggregate sums the request into the window of its key, and returns the response of the
+// produced sum. ok is false if the request is not aggregatable, and should be produced alone.
+// The sum is produced by the caller opening the window.
+func (a *aggregator) aggregate(req *proxy.Request, cmd string, produce func(req *proxy.Request) (*proxy.Response, error)) (resp *proxy.Response, ok bool, err error) {
+	inc, ok := a.parse(req, cmd)
+	if !ok {
+		return nil, false, nil
+	}
+
+	id := incrementID(cmd, inc)
+
+	a.mu.Lock()
+	if agg, open := a.windows[id]; open {
+		if !agg.add(inc) {
+			a.mu.Unlock()
+			// produced alone after the sum
+			agg.flush()
+			<-agg.produced
+			return nil, false, nil
+		}
+		agg.count++
+		a.mu.Unlock()
+		a.aggregated.Inc()
+
+		<-agg.produced
+		return agg.resp, true, agg.err
+	}
+
+	agg := &aggregate{hold: newHold(id, string(inc.key)), cmd: cmd, key: inc.key, field: inc.field, count: 1}
+	agg.add(inc)
+	a.windows[id] = agg
+	a.holds.add(agg.hold)
+	a.mu.Unlock()
+	a.aggregated.Inc()
+
+	timer := time.NewTimer(a.window)
+	select {
+	case <-timer.C:
+	case <-agg.flushed:
+		timer.Stop()
+	}
+
+	a.mu.Lock()
+	delete(a.windows, id)
+	a.mu.Unlock()
+
+	agg.resp, agg.err = produce(agg.request())
+	a.produced.Inc()
+	a.holds.done(agg.hold)
+
+	return agg.resp, true, agg.err
+}
+
+// flush closes the windows of the keys except the window id, and waits the sums produced
+func (a *aggregator) flush(keys [][]byte, id string) {
+	a.holds.flush(keys, id)
+}
@@ -0,0 +1,82 @@
+package proxysrv
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+func incrby(key string, delta int64) *proxy.Request {
+	return &proxy.Request{Cmd: "incrby", Args: [][]byte{[]byte(key), []byte(strconv.FormatInt(delta, 10))}}
+}
+
+// TestAggregatorFlushedByOtherWrite checks a write of the key not aggregatable, like SET,
+// closes the window early and is produced after the sum.
+func TestAggregatorFlushedByOtherWrite(t *testing.T) {
+	resetRegistry()
+	a := newAggregator(time.Minute, map[string]bool{"incrby": true})
+
+	var log orderLog
+	incrDone := make(chan struct{})
+
+	go func() {
+		defer close(incrDone)
+		a.aggregate(incrby("k", 1), "incrby", func(sum *proxy.Request) (*proxy.Response, error) {
+			log.record("incrby " + string(sum.Args[1]))
+			return &proxy.Response{}, nil
+		})
+	}()
+	waitOpen(t, &a.mu, func() bool { return len(a.windows) == 1 })
+
+	a.flush([][]byte{[]byte("k")}, "")
+	log.record("set")
+	<-incrDone
+
+	if got, want := log.get(), []string{"incrby 1", "set"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+// TestAggregatorOverflow checks the increment overflowing the sum is produced alone after it
+func TestAggregatorOverflow(t *testing.T) {
+	resetRegistry()
+	a := newAggregator(time.Minute, map[string]bool{"incrby": true})
+
+	var log orderLog
+	produce := func(sum *proxy.Request) (*proxy.Response, error) {
+		log.record("incrby " + string(sum.Args[1]))
+		return &proxy.Response{}, nil
+	}
+
+	incrDone := make(chan struct{})
+	go func() {
+		defer close(incrDone)
+		a.aggregate(incrby("k", 1<<62), "incrby", produce)
+	}()
+	waitOpen(t, &a.mu, func() bool { return len(a.windows) == 1 })
+
+	if _, ok, _ := a.aggregate(incrby("k", 1<<62), "incrby", produce); ok {
+		t.Fatal("overflowing increment aggregated")
+	}
+	log.record("incrby alone")
+	<-incrDone
+
+	if got, want := log.get(), []string{"incrby " + strconv.FormatInt(1<<62, 10), "incrby alone"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+func TestAggregatorParseScore(t *testing.T) {
+	resetRegistry()
+	a := newAggregator(time.Second, map[string]bool{"zincrby": true})
+
+	for score, want := range map[string]bool{"1.5": true, "-2": true, "nan": false, "inf": false, "-Inf": false, "x": false} {
+		req := &proxy.Request{Cmd: "zincrby", Args: [][]byte{[]byte("z"), []byte(score), []byte("m")}}
+		if _, ok := a.parse(req, "zincrby"); ok != want {
+			t.Errorf("parse(%v) = %v, want %v", score, ok, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package proxysrv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// coalescer coalesces the overwrites of a key or hash field in a window. The first write
+// opens the window, and the latest write in it is produced when the window closes. The
+// superseded ones wait for it produced, and get COALESCED or its failure, so the ack is as
+// durable as without coalescing. The window is a hold, see holdSet.
+type coalescer struct {
+	window    time.Duration
+	cmds      map[string]bool
+	mu        sync.Mutex
+	windows   map[string]*coalesceWindow // the latest window of each coalesce key
+	holds     *holdSet
+	eligible  prometheus.Counter
+	coalesced prometheus.Counter
+}
+
+// coalesceWindow is the window of a coalesce key, kept until the latest write is produced
+type coalesceWindow struct {
+	*hold
+	latest *coalesceWaiter
+	closed bool
+	prev   *coalesceWindow // the previous window of the key, produced before it
+	resp   *proxy.Response // of the latest write, set once produced
+	err    error
+}
+
+// coalesceWaiter is a write waiting for its window to close, done tells whether superseded
+type coalesceWaiter struct {
+	done chan bool
+}
+
+func newCoalescer(window time.Duration, cmds map[string]bool) *coalescer {
+	return &coalescer{
+		window:  window,
+		cmds:    cmds,
+		windows: make(map[string]*coalesceWindow),
+		holds:   newHoldSet(),
+		eligible: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "req_coalesce_eligible_total",
+			Help: "The number of requests eligible for coalescing by proxy",
+		}),
+		coalesced: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "req_coalesced_total",
+			Help: "The number of requests superseded in the coalescing window, not produced",
+		}),
+	}
+}
+
+// coalesceKey returns the key the request overwrites, ok is false if not coalescable
+func (c *coalescer) coalesceKey(req *proxy.Request, cmd string) (key string, ok bool) {
+	if c.window <= 0 || !c.cmds[cmd] || req.IdempotencyKey != "" || req.ApplyAtMs > 0 {
+		return "", false
+	}
+
+	switch cmd {
+	case "set":
+		// the options like NX or GET make it more than an overwrite
+		if len(req.Args) == 2 {
+			return "s:" + string(req.Args[0]), true
+		}
+	case "setex", "psetex":
+		if len(req.Args) == 3 {
+			return "s:" + string(req.Args[0]), true
+		}
+	case "hset", "hmset":
+		if len(req.Args) == 3 {
+			return "h:" + string(req.Args[0]) + "\x00" + string(req.Args[1]), true
+		}
+	}
+	return "", false
+}
+
+// coalesce waits the coalescing window of the request, and produces it by produce if it is
+// the latest write when the window closes. A superseded request gets the result of the latest
+// one. ok is false if the request is not coalescable, and it is not produced.
+func (c *coalescer) coalesce(req *proxy.Request, cmd string, produce func() (*proxy.Response, error)) (resp *proxy.Response, ok bool, err error) {
+	id, ok := c.coalesceKey(req, cmd)
+	if !ok {
+		return nil, false, nil
+	}
+	c.eligible.Inc()
+
+	w := &coalesceWaiter{done: make(chan bool, 1)}
+
+	c.mu.Lock()
+	win, ok := c.windows[id]
+	if ok && !win.closed {
+		win.latest.done <- true
+		win.latest = w
+		c.coalesced.Inc()
+	} else {
+		win = &coalesceWindow{hold: newHold(id, string(req.Args[0])), latest: w, prev: win}
+		c.windows[id] = win
+		c.holds.add(win.hold)
+		go c.close(win)
+	}
+	c.mu.Unlock()
+
+	// not canceled with the caller, the latest write is produced for all of the window
+	if <-w.done {
+		<-win.produced
+		if win.err != nil || win.resp == nil || isFailure(win.resp.Errno) {
+			return win.resp, true, win.err
+		}
+		return coalescedResponse(), true, nil
+	}
+
+	if win.prev != nil {
+		<-win.prev.produced
+		win.prev = nil
+	}
+
+	win.resp, win.err = produce()
+
+	c.mu.Lock()
+	if c.windows[win.id] == win {
+		delete(c.windows, win.id)
+	}
+	c.mu.Unlock()
+	c.holds.done(win.hold)
+
+	return win.resp, true, win.err
+}
+
+// close closes the window when it expires or is flushed, the latest write is produced
+func (c *coalescer) close(win *coalesceWindow) {
+	timer := time.NewTimer(c.window)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-win.flushed:
+	}
+
+	c.mu.Lock()
+	win.closed = true
+	win.latest.done <- false
+	c.mu.Unlock()
+}
+
+// flush closes the windows of the keys except the window id, and waits them produced
+func (c *coalescer) flush(keys [][]byte, id string) {
+	c.holds.flush(keys, id)
+}
+
+// coalescedResponse returns the response of a superseded write
+func coalescedResponse() *proxy.Response {
+	return &proxy.Response{Errno: proxy.Error_COALESCED, Message: "superseded by a later write"}
+}
@@ -0,0 +1,136 @@
+package proxysrv
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// resetRegistry lets the tests register the metrics of proxy again
+func resetRegistry() {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+}
+
+// orderLog records the order the writes are produced
+type orderLog struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (l *orderLog) record(write string) {
+	l.mu.Lock()
+	l.order = append(l.order, write)
+	l.mu.Unlock()
+}
+
+func (l *orderLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.order...)
+}
+
+// waitOpen waits the window of id opened
+func waitOpen(t *testing.T, mu *sync.Mutex, open func() bool) {
+	for i := 0; i < 1000; i++ {
+		mu.Lock()
+		ok := open()
+		mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("window not opened")
+}
+
+func setRequest(value string) *proxy.Request {
+	return &proxy.Request{Cmd: "set", Args: [][]byte{[]byte("k"), []byte(value)}}
+}
+
+func TestCoalescerLatestWins(t *testing.T) {
+	resetRegistry()
+	c := newCoalescer(20*time.Millisecond, map[string]bool{"set": true})
+
+	results := make(chan string, 2)
+	set := func(value string) {
+		resp, _, _ := c.coalesce(setRequest(value), "set", func() (*proxy.Response, error) {
+			results <- value + ":produced"
+			return &proxy.Response{}, nil
+		})
+		if resp.Errno == proxy.Error_COALESCED {
+			results <- value + ":superseded"
+		}
+	}
+
+	go set("1")
+	waitOpen(t, &c.mu, func() bool { return c.windows["s:k"] != nil })
+	go set("2")
+
+	got := map[string]bool{<-results: true, <-results: true}
+	want := map[string]bool{"1:superseded": true, "2:produced": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+}
+
+// TestCoalescerLatestFailed checks the superseded write waits for the latest produced, and
+// gets its failure instead of COALESCED.
+func TestCoalescerLatestFailed(t *testing.T) {
+	resetRegistry()
+	c := newCoalescer(20*time.Millisecond, map[string]bool{"set": true})
+
+	failed := &proxy.Response{Errno: proxy.Error_KAFKA_ERROR, Message: "kafka down"}
+	produce := func() (*proxy.Response, error) {
+		return failed, nil
+	}
+
+	first := make(chan *proxy.Response, 1)
+	go func() {
+		resp, _, _ := c.coalesce(setRequest("1"), "set", produce)
+		first <- resp
+	}()
+	waitOpen(t, &c.mu, func() bool { return c.windows["s:k"] != nil })
+
+	if resp, ok, _ := c.coalesce(setRequest("2"), "set", produce); !ok || resp != failed {
+		t.Fatalf("latest = %v, want %v", resp, failed)
+	}
+	if resp := <-first; resp != failed {
+		t.Fatalf("superseded = %v, want %v", resp, failed)
+	}
+}
+
+// TestCoalescerFlushedByOtherWrite checks a write of the key not coalescable, like DEL,
+// closes the window early and is produced after the held SET.
+func TestCoalescerFlushedByOtherWrite(t *testing.T) {
+	resetRegistry()
+	c := newCoalescer(time.Minute, map[string]bool{"set": true})
+
+	var log orderLog
+	setDone := make(chan struct{})
+
+	go func() {
+		defer close(setDone)
+		c.coalesce(setRequest("1"), "set", func() (*proxy.Response, error) {
+			log.record("set")
+			return &proxy.Response{}, nil
+		})
+	}()
+	waitOpen(t, &c.mu, func() bool { return c.windows["s:k"] != nil })
+
+	// a write of another key is not held
+	c.flush([][]byte{[]byte("other")}, "")
+	log.record("set other")
+
+	c.flush([][]byte{[]byte("k")}, "")
+	log.record("del")
+	<-setDone
+
+	if got, want := log.get(), []string{"set other", "set", "del"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package proxysrv
+
+import (
+	"sync"
+)
+
+// hold is a window of the coalescer or the aggregator holding the writes of a redis key
+type hold struct {
+	id       string // the window, writes with the same id join it
+	key      string
+	flushed  chan struct{}
+	once     sync.Once
+	produced chan struct{}
+}
+
+func newHold(id, key string) *hold {
+	return &hold{
+		id:       id,
+		key:      key,
+		flushed:  make(chan struct{}),
+		produced: make(chan struct{}),
+	}
+}
+
+// flush closes the window early
+func (h *hold) flush() {
+	h.once.Do(func() { close(h.flushed) })
+}
+
+// holdSet tracks the windows of the coalescer and the aggregator by redis key until produced.
+// A write of the key not joining a window closes the window early and waits for it produced
+// first, keeping the writes of a key in order.
+type holdSet struct {
+	mu    sync.Mutex
+	byKey map[string]map[*hold]struct{}
+}
+
+func newHoldSet() *holdSet {
+	return &holdSet{byKey: make(map[string]map[*hold]struct{})}
+}
+
+func (hs *holdSet) add(h *hold) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	holds, ok := hs.byKey[h.key]
+	if !ok {
+		holds = make(map[*hold]struct{})
+		hs.byKey[h.key] = holds
+	}
+	holds[h] = struct{}{}
+}
+
+// done marks the hold produced
+func (hs *holdSet) done(h *hold) {
+	hs.mu.Lock()
+	if holds := hs.byKey[h.key]; holds != nil {
+		delete(holds, h)
+		if len(holds) == 0 {
+			delete(hs.byKey, h.key)
+		}
+	}
+	hs.mu.Unlock()
+
+	close(h.produced)
+}
+
+// flush flushes the holds of the keys except the window id, and waits them produced
+func (hs *holdSet) flush(keys [][]byte, id string) {
+	var holds []*hold
+
+	hs.mu.Lock()
+	for _, key := range keys {
+		for h := range hs.byKey[string(key)] {
+			if id == "" || h.id != id {
+				holds = append(holds, h)
+			}
+		}
+	}
+	hs.mu.Unlock()
+
+	for _, h := range holds {
+		h.flush()
+		<-h.produced
+	}
+}
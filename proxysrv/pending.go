@@ -0,0 +1,134 @@
+package proxysrv
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/common/watermark"
+)
+
+// writeToken locates a produced message in kafka
+type writeToken struct {
+	partition int32
+	offset    int64
+}
+
+// pendingTracker tracks the keys produced by this proxy but not yet applied by the consumer.
+// Only the latest write of each key is kept, since all writes of a key land on the same
+// partition and are applied in order.
+type pendingTracker struct {
+	watcher  *watermark.Watcher
+	interval time.Duration
+	logger   *zap.Logger
+	mu       sync.Mutex
+	keys     map[string]writeToken
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newPendingTracker(watcher *watermark.Watcher, interval time.Duration, logger *zap.Logger) *pendingTracker {
+	return &pendingTracker{
+		watcher:  watcher,
+		interval: interval,
+		logger:   logger,
+		keys:     make(map[string]writeToken),
+		done:     make(chan struct{}),
+	}
+}
+
+func (t *pendingTracker) Start() {
+	t.wg.Add(1)
+	go t.loop()
+}
+
+func (t *pendingTracker) Stop() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+// Add records the write of key at partition/offset
+func (t *pendingTracker) Add(key []byte, partition int32, offset int64) {
+	t.mu.Lock()
+	if cur, ok := t.keys[string(key)]; !ok || cur.partition != partition || cur.offset < offset {
+		t.keys[string(key)] = writeToken{partition: partition, offset: offset}
+	}
+	t.mu.Unlock()
+}
+
+// Pending returns the latest write of key which is not yet applied
+func (t *pendingTracker) Pending(key []byte) (token writeToken, pending bool, err error) {
+	t.mu.Lock()
+	token, ok := t.keys[string(key)]
+	t.mu.Unlock()
+
+	if !ok {
+		return token, false, nil
+	}
+
+	if t.watcher.Applied(token.partition) >= token.offset {
+		t.remove(key, token)
+		return token, false, nil
+	}
+
+	applied, err := t.watcher.Fetch(token.partition)
+	if err != nil {
+		return token, true, err
+	}
+
+	if applied >= token.offset {
+		t.remove(key, token)
+		return token, false, nil
+	}
+
+	return token, true, nil
+}
+
+func (t *pendingTracker) remove(key []byte, token writeToken) {
+	t.mu.Lock()
+	if t.keys[string(key)] == token {
+		delete(t.keys, string(key))
+	}
+	t.mu.Unlock()
+}
+
+func (t *pendingTracker) loop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.gc()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// gc drops the keys whose latest write is applied
+func (t *pendingTracker) gc() {
+	t.mu.Lock()
+	size := len(t.keys)
+	t.mu.Unlock()
+
+	if size == 0 {
+		return
+	}
+
+	if err := t.watcher.Refresh(); err != nil {
+		t.logger.Error("failed to refresh applied offsets", zap.Error(err))
+		return
+	}
+
+	t.mu.Lock()
+	for key, token := range t.keys {
+		if t.watcher.Applied(token.partition) >= token.offset {
+			delete(t.keys, key)
+		}
+	}
+	t.mu.Unlock()
+}
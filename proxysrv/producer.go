@@ -0,0 +1,116 @@
+package proxysrv
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/common/partitioner"
+	"github.com/stn81/nec/config"
+)
+
+// newProducerConfig returns the kafka producer config of proxy
+func newProducerConfig(conf config.ProducerConfig) *sarama.Config {
+	clientConf := sarama.NewConfig()
+	clientConf.Producer.Retry.Max = config.Proxy.MaxRetries
+	clientConf.Producer.RequiredAcks = sarama.WaitForAll
+	clientConf.Producer.Return.Successes = true
+	clientConf.Producer.Return.Errors = true
+	clientConf.Producer.Compression = conf.Compression
+	clientConf.Producer.Flush.MaxMessages = conf.BatchSize
+	clientConf.Net.MaxOpenRequests = conf.MaxInflight
+	clientConf.Metadata.Full = true
+	clientConf.Version = config.Kafka.Version
+	clientConf.ClientID = config.Kafka.ClientID
+
+	if conf.Linger > 0 {
+		clientConf.Producer.Flush.Frequency = conf.Linger
+		clientConf.Producer.Flush.Messages = conf.BatchSize
+	}
+
+	if config.Proxy.Partitioner == partitioner.ModeSlot {
+		clientConf.Producer.Partitioner = partitioner.NewSlotPartitioner
+	}
+
+	return clientConf
+}
+
+// asyncProducer produces on top of the sarama.AsyncProducer with the semantics of the
+// sarama.SyncProducer. The messages of the concurrent calls are batched by the async producer,
+// and each call waits for the acks of its own messages on their completion channels.
+type asyncProducer struct {
+	producer sarama.AsyncProducer
+	wg       sync.WaitGroup
+}
+
+func newAsyncProducer(producer sarama.AsyncProducer) *asyncProducer {
+	p := &asyncProducer{producer: producer}
+
+	p.wg.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+
+	return p
+}
+
+// SendMessage produces the message and waits for its ack
+func (p *asyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	done := p.input(msg)
+
+	if err = <-done; err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+// SendMessages produces the messages and waits for all the acks, the failed ones are
+// returned in sarama.ProducerErrors.
+func (p *asyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	dones := make([]chan error, len(msgs))
+	for i, msg := range msgs {
+		dones[i] = p.input(msg)
+	}
+
+	var errs sarama.ProducerErrors
+	for i, done := range dones {
+		if err := <-done; err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: msgs[i], Err: err})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close flushes the buffered messages and closes the producer
+func (p *asyncProducer) Close() error {
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	return nil
+}
+
+// input enqueues the message with the completion channel in its metadata
+func (p *asyncProducer) input(msg *sarama.ProducerMessage) chan error {
+	done := make(chan error, 1)
+	msg.Metadata = done
+	p.producer.Input() <- msg
+	return done
+}
+
+func (p *asyncProducer) handleSuccesses() {
+	defer p.wg.Done()
+
+	for msg := range p.producer.Successes() {
+		msg.Metadata.(chan error) <- nil
+	}
+}
+
+func (p *asyncProducer) handleErrors() {
+	defer p.wg.Done()
+
+	for producerErr := range p.producer.Errors() {
+		producerErr.Msg.Metadata.(chan error) <- producerErr.Err
+	}
+}
@@ -0,0 +1,87 @@
+package proxysrv
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/stn81/nec/config"
+)
+
+const benchTopic = "nec_bench"
+
+// newBenchBroker starts a mock kafka broker leading the single partition of the bench topic,
+// replying each request after the latency.
+func newBenchBroker(b *testing.B, latency time.Duration) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(b, 1)
+	broker.SetLatency(latency)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(b).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(benchTopic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(b).SetVersion(3),
+	})
+	return broker
+}
+
+// benchmarkProducer produces from the parallel callers, each waiting for its own ack
+func benchmarkProducer(b *testing.B, newProducer func(addrs []string, conf *sarama.Config) (sarama.SyncProducer, error), conf config.ProducerConfig) {
+	config.Kafka.Version = sarama.V2_1_0_0
+	config.Kafka.ClientID = "nec_bench"
+	config.Proxy.MaxRetries = 3
+
+	broker := newBenchBroker(b, time.Millisecond)
+	defer broker.Close()
+
+	producer, err := newProducer([]string{broker.Addr()}, newProducerConfig(conf))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer producer.Close()
+
+	var seq int64
+	value := make([]byte, 256)
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := strconv.FormatInt(atomic.AddInt64(&seq, 1), 10)
+			msg := &sarama.ProducerMessage{
+				Topic: benchTopic,
+				Key:   sarama.StringEncoder(key),
+				Value: sarama.ByteEncoder(value),
+			}
+			if _, _, err := producer.SendMessage(msg); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkSyncProducer is the sarama.SyncProducer with the default batching
+func BenchmarkSyncProducer(b *testing.B) {
+	benchmarkProducer(b, sarama.NewSyncProducer, config.ProducerConfig{MaxInflight: 5})
+}
+
+// BenchmarkAsyncProducer lingers to fill the batches, amortizing the broker latency
+func BenchmarkAsyncProducer(b *testing.B) {
+	newProducer := func(addrs []string, conf *sarama.Config) (sarama.SyncProducer, error) {
+		producer, err := sarama.NewAsyncProducer(addrs, conf)
+		if err != nil {
+			return nil, err
+		}
+		return newAsyncProducer(producer), nil
+	}
+
+	benchmarkProducer(b, newProducer, config.ProducerConfig{
+		Linger:      time.Millisecond,
+		BatchSize:   64,
+		MaxInflight: 5,
+	})
+}
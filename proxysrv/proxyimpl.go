@@ -3,6 +3,7 @@ package proxysrv
 import (
 	"context"
 	"errors"
+	"path"
 	"strings"
 	"time"
 
@@ -18,30 +19,53 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/stn81/nec/common/health"
+	"github.com/stn81/nec/common/route"
+	"github.com/stn81/nec/common/watermark"
 	"github.com/stn81/nec/config"
 	"github.com/stn81/nec/proto/proxy"
 )
 
 const MaxReqSize = 1024 * 1024 // 1M
 
+const MaxIdempotencyKeySize = 256
+
+const cmdMulti = "multi"
+
+const spoolHealthName = "proxy_spool"
+
 var (
 	errRateLimitReached = errors.New("ratelimit reached")
+	errCrossSlot        = errors.New("keys span slots")
 )
 
 type proxyImpl struct {
 	cmdInfoMap   map[string]*redis.CommandInfo
+	readCmds     map[string]*redis.CommandInfo // the allowed read-only commands, not written
+	readClients  map[string]rdb.Client // route name to the client reading its keys
+	ownClients   []rdb.Client          // the read clients created for the routes
 	client       sarama.SyncProducer
+	watcher      *watermark.Watcher
+	pending      *pendingTracker
+	versions     *versionClock
+	coalescer    *coalescer
+	aggregator   *aggregator
+	spool        *spool
 	tokenBucket  *ratelimit.Bucket
 	logger       *zap.Logger
 	accessLogger *zap.Logger
 	total        prometheus.Counter
 	succ         prometheus.Counter
 	fail         prometheus.Counter
+	batchTotal   prometheus.Counter
 	processTime  prometheus.Histogram
 }
 
 func newProxyImpl(tokenBucket *ratelimit.Bucket, logger, accessLogger *zap.Logger) *proxyImpl {
 	return &proxyImpl{
+		versions:     &versionClock{},
+		coalescer:    newCoalescer(config.Proxy.CoalesceWindow, config.Proxy.CoalesceCmds),
+		aggregator:   newAggregator(config.Proxy.AggregateWindow, config.Proxy.AggregateCmds),
 		tokenBucket:  tokenBucket,
 		logger:       logger,
 		accessLogger: accessLogger,
@@ -57,6 +81,10 @@ func newProxyImpl(tokenBucket *ratelimit.Bucket, logger, accessLogger *zap.Logge
 			Name: "req_processed_fail",
 			Help: "The fail number of processed requests by proxy",
 		}),
+		batchTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "batch_req_processed_total",
+			Help: "The total number of processed batch requests by proxy",
+		}),
 		processTime: promauto.NewHistogram(prometheus.HistogramOpts{
 			Name: "req_process_time_ms",
 			Help: "The process time of proxy request in ms",
@@ -65,21 +93,13 @@ func newProxyImpl(tokenBucket *ratelimit.Bucket, logger, accessLogger *zap.Logge
 }
 
 func (s *proxyImpl) Init() error {
-	clientConf := sarama.NewConfig()
-	clientConf.Producer.Retry.Max = config.Proxy.MaxRetries
-	clientConf.Producer.RequiredAcks = sarama.WaitForAll
-	clientConf.Producer.Return.Successes = true
-	clientConf.Metadata.Full = true
-	clientConf.Version = config.Kafka.Version
-	clientConf.ClientID = config.Kafka.ClientID
-
-	client, err := sarama.NewSyncProducer(config.Kafka.BrokerAddrs, clientConf)
+	producer, err := sarama.NewAsyncProducer(config.Kafka.BrokerAddrs, newProducerConfig(config.Proxy.Producer))
 	if err != nil {
 		s.logger.Error("failed to create kafka producer client", zap.Error(err))
 		return err
 	}
 
-	s.client = client
+	s.client = newAsyncProducer(producer)
 
 	rdb := rdb.Get()
 	cmdInfoMap, err := rdb.Command().Result()
@@ -88,18 +108,82 @@ func (s *proxyImpl) Init() error {
 		return err
 	}
 
-	for cmd := range cmdInfoMap {
-		if allowed := config.Proxy.Commands[cmd]; !allowed {
-			delete(cmdInfoMap, cmd)
-		}
+	s.cmdInfoMap, s.readCmds = allowedCommands(cmdInfoMap, config.Proxy.Commands)
+
+	s.initReadClients()
+
+	// the applied offsets are published to the redis of the sink by its consumer group
+	sink, err := config.WaitAppliedSink()
+	if err != nil {
+		s.logger.Error("failed to find the sink to wait applied", zap.Error(err))
+		return err
+	}
+
+	appliedClient := rdb
+	if sink.Name != config.DefaultSink {
+		appliedClient = route.NewClient(sink.Redis.Config)
+		s.ownClients = append(s.ownClients, appliedClient)
 	}
 
-	s.cmdInfoMap = cmdInfoMap
+	s.watcher = watermark.NewWatcher(appliedClient, sink.ConsumerGroup, config.Kafka.Topic, config.Proxy.WaitAppliedPoll, s.logger)
+	s.watcher.Start()
+
+	s.pending = newPendingTracker(s.watcher, config.Proxy.PendingGC, s.logger)
+	s.pending.Start()
+
+	if config.Proxy.Spool.Enabled {
+		dir := config.Proxy.Spool.Dir
+		if dir == "" {
+			dir = path.Join(config.Main.LogDir, "spool")
+		}
+
+		if s.spool, err = openSpool(config.Proxy.Spool, dir, s.drain, s.logger); err != nil {
+			s.logger.Error("failed to open spool", zap.String("dir", dir), zap.Error(err))
+			return err
+		}
+
+		s.spool.Start()
+		health.Register(spoolHealthName, s.spool.Status)
+	}
 
 	return nil
 }
 
+// allowedCommands returns the write and the read-only commands allowed by config
+func allowedCommands(cmdInfoMap map[string]*redis.CommandInfo, allowed map[string]bool) (writes, reads map[string]*redis.CommandInfo) {
+	writes = make(map[string]*redis.CommandInfo)
+	reads = make(map[string]*redis.CommandInfo)
+
+	for cmd, cmdInfo := range cmdInfoMap {
+		switch {
+		case !allowed[cmd]:
+		case cmdInfo.ReadOnly:
+			reads[cmd] = cmdInfo
+		default:
+			writes[cmd] = cmdInfo
+		}
+	}
+	return writes, reads
+}
+
 func (s *proxyImpl) Uninit() error {
+	if s.spool != nil {
+		health.Unregister(spoolHealthName)
+		s.spool.Stop()
+	}
+
+	if s.pending != nil {
+		s.pending.Stop()
+	}
+
+	if s.watcher != nil {
+		s.watcher.Stop()
+	}
+
+	for _, client := range s.ownClients {
+		client.Close()
+	}
+
 	if s.client != nil {
 		if err := s.client.Close(); err != nil {
 			s.logger.Error("failed to close kafka producer client", zap.Error(err))
@@ -127,10 +211,12 @@ func (s *proxyImpl) Do(ctx context.Context, req *proxy.Request) (resp *proxy.Res
 func (s *proxyImpl) do(ctx context.Context, req *proxy.Request) (resp *proxy.Response, err error) {
 	begin := time.Now()
 
-	cmd, firstKey, err := s.check(req)
+	cmd, keys, err := s.check(req)
 	switch {
 	case err == errRateLimitReached:
 		return &proxy.Response{Errno: proxy.Error_RATELIMIT, Message: "ratelimit reached"}, nil
+	case err == errCrossSlot:
+		return &proxy.Response{Errno: proxy.Error_CROSSSLOT, Message: err.Error()}, nil
 	case err != nil:
 		s.logger.Error("proxy request check failed",
 			zap.String("request", utils.ToJSON(req)),
@@ -138,57 +224,372 @@ func (s *proxyImpl) do(ctx context.Context, req *proxy.Request) (resp *proxy.Res
 		return nil, err
 	}
 
+	s.stamp(req)
+
+	resp, err = s.dispatch(req, cmd, keys, begin)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// dispatch produces the checked request through the coalescing and aggregating windows. The
+// windows of its keys it doesn't join are flushed and produced first, keeping the writes of
+// a key in order.
+func (s *proxyImpl) dispatch(req *proxy.Request, cmd string, keys [][]byte, begin time.Time) (*proxy.Response, error) {
+	coalesceID, _ := s.coalescer.coalesceKey(req, cmd)
+	s.coalescer.flush(keys, coalesceID)
+	s.aggregator.flush(keys, s.aggregator.windowID(req, cmd))
+
+	produce := func() (*proxy.Response, error) {
+		resp, ok, err := s.aggregator.aggregate(req, cmd, func(sum *proxy.Request) (*proxy.Response, error) {
+			s.stamp(sum)
+			return s.produce(sum, cmd, keys, begin)
+		})
+		if !ok {
+			resp, err = s.produce(req, cmd, keys, begin)
+		}
+		return resp, err
+	}
+
+	if resp, ok, err := s.coalescer.coalesce(req, cmd, produce); ok {
+		return resp, err
+	}
+	return produce()
+}
+
+// produce splits the checked request, and produces the parts to kafka. A non-nil resp with
+// nil err means the request is rejected with the errno in it. The error of a single part is
+// returned with its response.
+func (s *proxyImpl) produce(req *proxy.Request, cmd string, keys [][]byte, begin time.Time) (resp *proxy.Response, err error) {
+	parts := s.split(req, cmd, keys)
+	if resp, err = s.prepare(parts); resp != nil || err != nil {
+		return resp, err
+	}
+
+	s.send(parts, begin)
+
+	if len(parts) == 1 && parts[0].err != nil {
+		return parts[0].resp, parts[0].err
+	}
+
+	return mergeResponses(parts), nil
+}
+
+// prepare builds the kafka messages of the parts of a checked request.
+// A non-nil resp means the request is rejected with the errno in it.
+func (s *proxyImpl) prepare(parts []*part) (resp *proxy.Response, err error) {
+	for _, p := range parts {
+		if p.message, resp, err = s.newMessage(p.req, p.cmd, p.keys[0]); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	return nil, nil
+}
+
+// send produces the prepared parts to kafka and waits for the acks, the response of each
+// part is filled with its partition/offset or the kafka error.
+func (s *proxyImpl) send(parts []*part, begin time.Time) {
+	if s.spool != nil && s.spool.pending() {
+		s.spoolParts(parts, begin)
+		return
+	}
+
+	failed := make(map[*sarama.ProducerMessage]error)
+
+	if len(parts) == 1 {
+		message := parts[0].message
+		if _, _, err := s.client.SendMessage(message); err != nil {
+			failed[message] = err
+		}
+	} else {
+		messages := make([]*sarama.ProducerMessage, 0, len(parts))
+		for _, p := range parts {
+			messages = append(messages, p.message)
+		}
+
+		if err := s.client.SendMessages(messages); err != nil {
+			if producerErrs, ok := err.(sarama.ProducerErrors); ok {
+				for _, producerErr := range producerErrs {
+					failed[producerErr.Msg] = producerErr.Err
+				}
+			} else {
+				for _, message := range messages {
+					failed[message] = err
+				}
+			}
+		}
+	}
+
+	elapsed := time.Since(begin).Milliseconds()
+
+	for _, p := range parts {
+		message := p.message
+
+		if err, ok := failed[message]; ok {
+			s.logger.Error("proxy send message to kafka failed",
+				zap.String("command", p.cmd),
+				zap.String("key", string(p.keys[0])),
+				zap.Error(err),
+			)
+			if s.spool == nil || s.spoolPart(p) != nil {
+				p.err = err
+				p.resp = &proxy.Response{Errno: proxy.Error_KAFKA_ERROR, Message: err.Error()}
+			}
+			continue
+		}
+
+		for _, key := range p.keys {
+			s.pending.Add(key, message.Partition, message.Offset)
+		}
+
+		s.accessLogger.Info("send request to kakfa success",
+			zap.String("command", p.cmd),
+			zap.String("key", string(p.keys[0])),
+			zap.Int32("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Int64("elapsed_ms", elapsed),
+		)
+		p.resp = &proxy.Response{Partition: message.Partition, Offset: message.Offset}
+	}
+
+	s.processTime.Observe(float64(elapsed))
+}
+
+// spoolParts spools the parts behind the pending ones in the spool without trying kafka,
+// to keep the order of the writes.
+func (s *proxyImpl) spoolParts(parts []*part, begin time.Time) {
+	for _, p := range parts {
+		if err := s.spoolPart(p); err != nil {
+			p.err = err
+			p.resp = &proxy.Response{Errno: proxy.Error_KAFKA_ERROR, Message: "kafka unavailable: " + err.Error()}
+		}
+	}
+
+	s.processTime.Observe(float64(time.Since(begin).Milliseconds()))
+}
+
+// spoolPart spools the message of the part, and fills the response with SPOOLED
+func (s *proxyImpl) spoolPart(p *part) error {
+	key, _ := p.message.Key.Encode()
+	value, _ := p.message.Value.Encode()
+
+	if err := s.spool.append(key, value); err != nil {
+		s.logger.Error("proxy spool message failed",
+			zap.String("command", p.cmd),
+			zap.String("key", string(p.keys[0])),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	s.accessLogger.Info("spool request to disk success",
+		zap.String("command", p.cmd),
+		zap.String("key", string(p.keys[0])),
+	)
+	p.resp = &proxy.Response{Errno: proxy.Error_SPOOLED, Message: "spooled"}
+	return nil
+}
+
+// drain produces the spooled messages to kafka in order. The whole batch is retried on any
+// failure, so the messages of a key are never reordered but may be duplicated.
+func (s *proxyImpl) drain(batch []*spoolRecord) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(batch))
+	for _, rec := range batch {
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic: config.Kafka.Topic,
+			Key:   sarama.ByteEncoder(rec.key),
+			Value: sarama.ByteEncoder(rec.value),
+		})
+	}
+
+	if err := s.client.SendMessages(messages); err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		key, _ := message.Key.Encode()
+		s.pending.Add(key, message.Partition, message.Offset)
+	}
+	return nil
+}
+
+func (s *proxyImpl) DoBatch(ctx context.Context, batch *proxy.BatchRequest) (resp *proxy.BatchResponse, err error) {
+	s.batchTotal.Inc()
+
+	resp, err = s.doBatch(ctx, batch)
+	if err != nil {
+		s.total.Add(float64(len(batch.Requests)))
+		s.fail.Add(float64(len(batch.Requests)))
+		return nil, err
+	}
+
+	for _, itemResp := range resp.Responses {
+		s.total.Inc()
+		if isFailure(itemResp.Errno) {
+			s.fail.Inc()
+		} else {
+			s.succ.Inc()
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *proxyImpl) doBatch(ctx context.Context, batch *proxy.BatchRequest) (*proxy.BatchResponse, error) {
+	begin := time.Now()
+
+	if len(batch.Requests) > config.Proxy.MaxBatchSize {
+		return nil, status.Error(codes.InvalidArgument, "too many requests in batch")
+	}
+
+	var (
+		resps     = make([]*proxy.Response, len(batch.Requests))
+		itemParts = make([][]*part, len(batch.Requests))
+		allParts  = make([]*part, 0, len(batch.Requests))
+	)
+
+	for i, req := range batch.Requests {
+		cmd, keys, err := s.validate(req)
+		if err != nil {
+			s.logger.Error("proxy request check failed",
+				zap.String("request", utils.ToJSON(req)),
+				zap.Error(err))
+			resps[i] = errorResponse(err)
+			continue
+		}
+
+		s.stamp(req)
+
+		s.coalescer.flush(keys, "")
+		s.aggregator.flush(keys, "")
+
+		parts := s.split(req, cmd, keys)
+		resp, err := s.prepare(parts)
+		switch {
+		case err != nil:
+			resps[i] = errorResponse(err)
+			continue
+		case resp != nil:
+			resps[i] = resp
+			continue
+		}
+
+		itemParts[i] = parts
+		allParts = append(allParts, parts...)
+	}
+
+	if len(allParts) > 0 && !s.tokenBucket.WaitMaxDuration(int64(len(allParts)), time.Millisecond*100) {
+		for i, parts := range itemParts {
+			if parts != nil {
+				resps[i] = &proxy.Response{Errno: proxy.Error_RATELIMIT, Message: "ratelimit reached"}
+				itemParts[i] = nil
+			}
+		}
+		allParts = nil
+	}
+
+	if len(allParts) > 0 {
+		s.send(allParts, begin)
+	}
+
+	for i, parts := range itemParts {
+		if parts != nil {
+			resps[i] = mergeResponses(parts)
+		}
+	}
+
+	return &proxy.BatchResponse{Responses: resps}, nil
+}
+
+// WaitApplied blocks until the consumer group has applied the message at the partition/offset
+// returned by Do, so the caller can read its own write from redis.
+func (s *proxyImpl) WaitApplied(ctx context.Context, req *proxy.WaitAppliedRequest) (*proxy.WaitAppliedResponse, error) {
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 || timeout > config.Proxy.MaxWaitApplied {
+		timeout = config.Proxy.MaxWaitApplied
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	applied, err := s.watcher.Wait(ctx, req.Partition, req.Offset)
+	switch {
+	case err == context.DeadlineExceeded:
+		return &proxy.WaitAppliedResponse{
+			Errno:         proxy.Error_TIMEOUT,
+			Message:       "wait applied timeout",
+			AppliedOffset: applied,
+		}, nil
+	case err != nil:
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	return &proxy.WaitAppliedResponse{AppliedOffset: applied}, nil
+}
+
+// newMessage marshals the checked request into the kafka message to produce.
+// A non-nil resp means the request is rejected with the errno in it.
+func (s *proxyImpl) newMessage(req *proxy.Request, cmd string, firstKey []byte) (message *sarama.ProducerMessage, resp *proxy.Response, err error) {
 	value, err := proto.Marshal(req)
 	if err != nil {
 		s.logger.Error("proxy marshal request to pb failed",
 			zap.String("request", utils.ToJSON(req)),
 			zap.Error(err),
 		)
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(value) > MaxReqSize {
 		s.logger.Error("proxy request size too large",
-			zap.String("command", req.Cmd),
+			zap.String("command", cmd),
 			zap.String("key", string(firstKey)),
 			zap.Int("size", len(value)),
 		)
-		return &proxy.Response{Errno: proxy.Error_SIZE_TOO_LARGE, Message: "size too large"}, nil
+		return nil, &proxy.Response{Errno: proxy.Error_SIZE_TOO_LARGE, Message: "size too large"}, nil
 	}
 
-	message := &sarama.ProducerMessage{
+	message = &sarama.ProducerMessage{
 		Topic: config.Kafka.Topic,
 		Key:   sarama.ByteEncoder(firstKey),
 		Value: sarama.ByteEncoder(value),
 	}
 
-	partition, offset, err := s.client.SendMessage(message)
-	if err != nil {
-		s.logger.Error("proxy send message to kafka failed",
-			zap.String("command", cmd),
-			zap.String("key", string(firstKey)),
-			zap.Error(err),
-		)
-		return nil, err
+	return message, nil, nil
+}
+
+func (s *proxyImpl) check(req *proxy.Request) (cmd string, keys [][]byte, err error) {
+	if !s.tokenBucket.WaitMaxDuration(1, time.Millisecond*100) {
+		return "", nil, errRateLimitReached
 	}
 
-	elapsed := time.Since(begin).Milliseconds()
-	s.accessLogger.Info("send request to kakfa success",
-		zap.String("command", cmd),
-		zap.String("key", string(firstKey)),
-		zap.Int32("partition", partition),
-		zap.Int64("offset", offset),
-		zap.Int64("elapsed_ms", elapsed),
-	)
+	return s.validate(req)
+}
 
-	s.processTime.Observe(float64(elapsed))
+// validate validates the request, and returns the keys of it
+func (s *proxyImpl) validate(req *proxy.Request) (cmd string, keys [][]byte, err error) {
+	if len(req.IdempotencyKey) > MaxIdempotencyKeySize {
+		return "", nil, status.Error(codes.InvalidArgument, "idempotency key too long")
+	}
 
-	return &proxy.Response{}, nil
-}
+	if req.Version < 0 {
+		return "", nil, status.Error(codes.InvalidArgument, "negative version")
+	}
 
-func (s *proxyImpl) check(req *proxy.Request) (cmd string, firstKey []byte, err error) {
-	if !s.tokenBucket.WaitMaxDuration(1, time.Millisecond*100) {
-		return "", nil, errRateLimitReached
+	if req.ApplyAtMs > 0 && config.Consumer.DelayTopic == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "scheduled writes not enabled")
+	}
+
+	if req.ApplyAtMs > time.Now().Add(config.Consumer.MaxScheduleAhead).UnixNano()/int64(time.Millisecond) {
+		return "", nil, status.Error(codes.InvalidArgument, "apply_at beyond max_schedule_ahead")
+	}
+
+	if strings.ToLower(req.Cmd) == cmdMulti {
+		return s.validateMulti(req)
+	}
+
+	if len(req.Multi) > 0 {
+		return "", nil, status.Error(codes.InvalidArgument, "grouped commands without multi")
 	}
 
 	if len(req.Args) < 1 {
@@ -217,5 +618,78 @@ func (s *proxyImpl) check(req *proxy.Request) (cmd string, firstKey []byte, err
 		return "", nil, status.Error(codes.InvalidArgument, "invalid redis command arity")
 	}
 
-	return cmd, req.Args[cmdInfo.FirstKeyPos-1], nil
+	if keys, err = commandKeys(cmd, cmdInfo, req.Args); err != nil {
+		return "", nil, err
+	}
+
+	if len(keys) == 0 {
+		return "", nil, status.Error(codes.InvalidArgument, "redis command without key not supported")
+	}
+
+	// the consumer orders the messages by the slot of the first key, so the keys of a message
+	// must share the slot even if not on redis cluster
+	if !splittable(cmd, cmdInfo) && !sameSlot(keys) {
+		return "", nil, errCrossSlot
+	}
+
+	if err = checkRoutes(keys, !splittable(cmd, cmdInfo)); err != nil {
+		return "", nil, err
+	}
+
+	if err = checkTaggable(req, keys); err != nil {
+		return "", nil, err
+	}
+
+	return cmd, keys, nil
+}
+
+// validateMulti validates each grouped command of the multi, and the keys of them must
+// share one slot, so the group can be applied atomically on redis cluster and in order
+// with the other writes of the keys by the consumer.
+func (s *proxyImpl) validateMulti(req *proxy.Request) (cmd string, keys [][]byte, err error) {
+	switch {
+	case len(req.Args) > 0:
+		return "", nil, status.Error(codes.InvalidArgument, "multi takes no args")
+	case len(req.Multi) == 0:
+		return "", nil, status.Error(codes.InvalidArgument, "empty multi")
+	case len(req.Multi) > config.Proxy.MaxBatchSize:
+		return "", nil, status.Error(codes.InvalidArgument, "too many commands in multi")
+	}
+
+	for _, sub := range req.Multi {
+		if strings.ToLower(sub.Cmd) == cmdMulti || len(sub.Multi) > 0 {
+			return "", nil, status.Error(codes.InvalidArgument, "nested multi")
+		}
+		if sub.IdempotencyKey != "" {
+			return "", nil, status.Error(codes.InvalidArgument, "idempotency key in grouped command")
+		}
+
+		_, subKeys, err := s.validate(sub)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, subKeys...)
+	}
+
+	if !sameSlot(keys) {
+		return "", nil, errCrossSlot
+	}
+
+	if err = checkRoutes(keys, true); err != nil {
+		return "", nil, err
+	}
+
+	if err = checkTaggable(req, keys); err != nil {
+		return "", nil, err
+	}
+
+	return cmdMulti, keys, nil
+}
+
+// errorResponse converts the check error of a batch item to its response
+func errorResponse(err error) *proxy.Response {
+	if err == errCrossSlot {
+		return &proxy.Response{Errno: proxy.Error_CROSSSLOT, Message: err.Error()}
+	}
+	return &proxy.Response{Errno: proxy.Error_INVALID_ARGUMENT, Message: status.Convert(err).Message()}
 }
@@ -0,0 +1,139 @@
+package proxysrv
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/stn81/kate/utils"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// Ingest implements the bidi-streaming ingest. Each request is acked as soon as kafka
+// confirms it, so acks may come back out of order and carry the client sequence number.
+//
+// Flow control: after a request is read from the stream, a token is taken from the token
+// bucket and a slot of the in-flight window, blocking before the next read, so at most one
+// request per stream waits outside the window and a fast producer is pushed back by grpc
+// flow control instead of being rejected.
+func (s *proxyImpl) Ingest(stream proxy.Proxy_IngestServer) error {
+	var (
+		ctx        = stream.Context()
+		wg         sync.WaitGroup
+		acks       = make(chan *proxy.IngestAck, config.Proxy.MaxInflight)
+		inflight   = make(chan struct{}, config.Proxy.MaxInflight)
+		senderDone = make(chan error, 1)
+	)
+
+	go func() {
+		var err error
+		for ack := range acks {
+			// keep draining after a send failure, so no ingest worker is blocked
+			if err == nil {
+				err = stream.Send(ack)
+			}
+		}
+		senderDone <- err
+	}()
+
+	err := s.ingest(ctx, stream, func(item *proxy.IngestRequest) {
+		inflight <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-inflight
+				wg.Done()
+			}()
+
+			acks <- &proxy.IngestAck{
+				Seq:      item.Seq,
+				Response: s.ingestOne(item.Request),
+			}
+		}()
+	})
+
+	wg.Wait()
+	close(acks)
+
+	if sendErr := <-senderDone; err == nil {
+		err = sendErr
+	}
+
+	return err
+}
+
+func (s *proxyImpl) ingest(ctx context.Context, stream proxy.Proxy_IngestServer, dispatch func(*proxy.IngestRequest)) error {
+	for {
+		item, err := stream.Recv()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if err = s.waitToken(ctx); err != nil {
+			return err
+		}
+
+		dispatch(item)
+	}
+}
+
+func (s *proxyImpl) ingestOne(req *proxy.Request) *proxy.Response {
+	begin := time.Now()
+
+	s.total.Inc()
+
+	if req == nil {
+		s.fail.Inc()
+		return &proxy.Response{Errno: proxy.Error_INVALID_ARGUMENT, Message: "missing request"}
+	}
+
+	cmd, keys, err := s.validate(req)
+	if err != nil {
+		s.logger.Error("proxy request check failed",
+			zap.String("request", utils.ToJSON(req)),
+			zap.Error(err))
+		s.fail.Inc()
+		return errorResponse(err)
+	}
+
+	s.stamp(req)
+
+	resp, err := s.dispatch(req, cmd, keys, begin)
+	if resp == nil {
+		resp = errorResponse(err)
+	}
+
+	if isFailure(resp.Errno) {
+		s.fail.Inc()
+	} else {
+		s.succ.Inc()
+	}
+
+	return resp
+}
+
+// waitToken blocks until a token is available in the token bucket or the ctx is done.
+func (s *proxyImpl) waitToken(ctx context.Context) error {
+	d := s.tokenBucket.Take(1)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
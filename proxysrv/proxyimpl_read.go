@@ -0,0 +1,171 @@
+package proxysrv
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/stn81/nec/common/route"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// Get reads the key from redis, and reports whether writes to it are still in flight
+func (s *proxyImpl) Get(ctx context.Context, req *proxy.GetRequest) (*proxy.ReadResponse, error) {
+	return s.read(ctx, req.Key, req.WaitPending, req.TimeoutMs, func(client rdb.Client) ([]byte, error) {
+		return client.Get(string(req.Key)).Bytes()
+	})
+}
+
+// HGet reads the hash field from redis, and reports whether writes to the key are still in flight
+func (s *proxyImpl) HGet(ctx context.Context, req *proxy.HGetRequest) (*proxy.ReadResponse, error) {
+	return s.read(ctx, req.Key, req.WaitPending, req.TimeoutMs, func(client rdb.Client) ([]byte, error) {
+		return client.HGet(string(req.Key), string(req.Field)).Bytes()
+	})
+}
+
+func (s *proxyImpl) read(
+	ctx context.Context,
+	key []byte,
+	waitPending bool,
+	timeoutMs int64,
+	get func(rdb.Client) ([]byte, error),
+) (*proxy.ReadResponse, error) {
+	if len(key) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty key")
+	}
+
+	resp := &proxy.ReadResponse{}
+
+	token, pending, err := s.pending.Pending(key)
+	if err != nil {
+		s.logger.Error("failed to check pending writes", zap.ByteString("key", key), zap.Error(err))
+	}
+
+	if pending && waitPending {
+		timeout := time.Duration(timeoutMs) * time.Millisecond
+		if timeout <= 0 || timeout > config.Proxy.MaxWaitApplied {
+			timeout = config.Proxy.MaxWaitApplied
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		_, err = s.watcher.Wait(waitCtx, token.partition, token.offset)
+		cancel()
+
+		switch {
+		case err == context.DeadlineExceeded:
+			resp.Errno = proxy.Error_TIMEOUT
+			resp.Message = "wait pending writes timeout"
+		case err != nil:
+			return nil, status.Error(codes.Canceled, err.Error())
+		default:
+			pending = false
+		}
+	}
+
+	if pending {
+		resp.Pending = true
+		resp.PendingPartition = token.partition
+		resp.PendingOffset = token.offset
+	}
+
+	client, err := s.readClient(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := get(client)
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		s.logger.Error("failed to read redis", zap.ByteString("key", key), zap.Error(err))
+		return nil, status.Error(codes.Unavailable, err.Error())
+	default:
+		resp.Value = value
+		resp.Exists = true
+	}
+
+	return resp, nil
+}
+
+// initReadClients creates the clients reading the keys of the routes, the routes of the same
+// target and db share one. The routes to the default redis read by the global client.
+func (s *proxyImpl) initReadClients() {
+	table := route.Get()
+	if !table.Enabled() {
+		return
+	}
+
+	s.readClients = make(map[string]rdb.Client)
+	shared := make(map[string]rdb.Client)
+
+	for _, rule := range table.Rules() {
+		name := rule.Target
+		if rule.DB >= 0 {
+			name += "/" + strconv.Itoa(rule.DB)
+		}
+
+		client, ok := shared[name]
+		if !ok {
+			client = s.newReadClient(rule)
+			shared[name] = client
+		}
+		s.readClients[rule.Name] = client
+	}
+}
+
+func (s *proxyImpl) newReadClient(rule *route.Rule) rdb.Client {
+	if rule.Target == config.DefaultSink && (rule.DB < 0 || rule.DB == config.Redis.DB) {
+		return rdb.Get()
+	}
+
+	// the routes are validated against the sinks on loading
+	var rdbConf rdb.Config
+	for _, sink := range config.Sinks {
+		if sink.Name == rule.Target {
+			rdbConf = *sink.Redis.Config
+		}
+	}
+	if rule.DB >= 0 {
+		rdbConf.DB = rule.DB
+	}
+
+	client := route.NewClient(&rdbConf)
+	s.ownClients = append(s.ownClients, client)
+	return client
+}
+
+// readCmdClient returns the client to read the keys of the read-only command by their route,
+// which they must share
+func (s *proxyImpl) readCmdClient(cmd string, args [][]byte) (rdb.Client, error) {
+	keys, err := commandKeys(cmd, s.readCmds[cmd], args)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "redis command without key not supported")
+	}
+	if err = checkRoutes(keys, true); err != nil {
+		return nil, err
+	}
+	return s.readClient(keys[0])
+}
+
+// readClient returns the client to read the key by its route, as the consumer applies it
+func (s *proxyImpl) readClient(key []byte) (rdb.Client, error) {
+	if s.readClients == nil {
+		return rdb.Get(), nil
+	}
+
+	rule := route.Get().Match(string(key))
+	if rule == nil {
+		return nil, status.Error(codes.InvalidArgument, "key matches no route")
+	}
+	return s.readClients[rule.Name], nil
+}
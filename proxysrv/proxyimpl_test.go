@@ -0,0 +1,215 @@
+package proxysrv
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stn81/kate/rdb"
+
+	"github.com/stn81/nec/common/route"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+func testCmdInfoMap() map[string]*redis.CommandInfo {
+	return map[string]*redis.CommandInfo{
+		"set":    {Name: "set", Arity: -3, FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1},
+		"rename": {Name: "rename", Arity: 3, FirstKeyPos: 1, LastKeyPos: 2, StepCount: 1},
+		"del":    {Name: "del", Arity: -2, FirstKeyPos: 1, LastKeyPos: -1, StepCount: 1},
+	}
+}
+
+func args(values ...string) [][]byte {
+	var out [][]byte
+	for _, value := range values {
+		out = append(out, []byte(value))
+	}
+	return out
+}
+
+// TestValidateCrossSlot checks the keys of a multi or an unsplittable command must share the
+// slot even if redis is not a cluster, as the consumer orders the messages by the slot of the
+// first key only.
+func TestValidateCrossSlot(t *testing.T) {
+	defer func(cluster bool, batch int) {
+		config.Redis.ClusterEnabled, config.Proxy.MaxBatchSize = cluster, batch
+	}(config.Redis.ClusterEnabled, config.Proxy.MaxBatchSize)
+	config.Proxy.MaxBatchSize = 10
+
+	s := &proxyImpl{cmdInfoMap: testCmdInfoMap()}
+
+	multi := func(keys ...string) *proxy.Request {
+		req := &proxy.Request{Cmd: "multi"}
+		for _, key := range keys {
+			req.Multi = append(req.Multi, &proxy.Request{Cmd: "set", Args: args(key, "v")})
+		}
+		return req
+	}
+
+	tests := []struct {
+		req   *proxy.Request
+		cross bool
+	}{
+		{req: multi("a", "b"), cross: true},
+		{req: multi("{user:1}a", "{user:1}b")},
+		{req: &proxy.Request{Cmd: "rename", Args: args("a", "b")}, cross: true},
+		{req: &proxy.Request{Cmd: "rename", Args: args("{user:1}a", "{user:1}b")}},
+		// split by key
+		{req: &proxy.Request{Cmd: "del", Args: args("a", "b")}},
+	}
+
+	for _, cluster := range []bool{true, false} {
+		config.Redis.ClusterEnabled = cluster
+		for _, tt := range tests {
+			if _, _, err := s.validate(tt.req); (err == errCrossSlot) != tt.cross {
+				t.Errorf("cluster %v: validate(%v %v) = %v, want cross slot %v", cluster, tt.req.Cmd, tt.req.Args, err, tt.cross)
+			}
+		}
+	}
+}
+
+// TestReadClient checks the keys are read from the target and db of their routes
+func TestReadClient(t *testing.T) {
+	defer func(sinks []*config.SinkConfig) {
+		config.Sinks = sinks
+		route.Init(nil)
+	}(config.Sinks)
+
+	redisConf := func() *config.RedisConfig {
+		return &config.RedisConfig{Config: &rdb.Config{Addrs: []string{"127.0.0.1:6379"}}}
+	}
+	config.Sinks = []*config.SinkConfig{
+		{Name: config.DefaultSink, Enabled: true, Redis: redisConf()},
+		{Name: "other", Redis: redisConf()},
+	}
+
+	err := route.Init([]*route.Rule{
+		{Name: "main", Kind: route.KindPrefix, Pattern: "main:", Target: config.DefaultSink, DB: -1},
+		{Name: "a", Kind: route.KindPrefix, Pattern: "a:", Target: "other", DB: 1},
+		{Name: "b", Kind: route.KindPrefix, Pattern: "b:", Target: "other", DB: 1},
+		{Name: "c", Kind: route.KindPrefix, Pattern: "c:", Target: "other", DB: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &proxyImpl{}
+	s.initReadClients()
+	defer func() {
+		for _, client := range s.ownClients {
+			client.Close()
+		}
+	}()
+
+	client := func(key string) rdb.Client {
+		c, err := s.readClient([]byte(key))
+		if err != nil {
+			t.Fatalf("readClient(%v): %v", key, err)
+		}
+		return c
+	}
+
+	if client("main:1") != rdb.Get() {
+		t.Error("the key of the default redis not read by the global client")
+	}
+	if client("a:1") != client("b:1") {
+		t.Error("the routes of the same target and db not sharing the client")
+	}
+	if client("a:1") == client("c:1") || client("a:1") == rdb.Get() {
+		t.Error("the routes of other dbs sharing the client")
+	}
+	if db := client("c:1").(*redis.Client).Options().DB; db != 2 {
+		t.Errorf("db = %v, want 2", db)
+	}
+	if _, err := s.readClient([]byte("x:1")); err == nil {
+		t.Error("the key matching no route read")
+	}
+}
+
+// TestValidateIdempotencyTaggable checks the idempotent write of a key not taggable is rejected
+// only on redis cluster.
+func TestValidateIdempotencyTaggable(t *testing.T) {
+	defer func(cluster bool, sinks []*config.SinkConfig) {
+		config.Redis.ClusterEnabled, config.Sinks = cluster, sinks
+	}(config.Redis.ClusterEnabled, config.Sinks)
+	config.Sinks = nil
+
+	s := &proxyImpl{cmdInfoMap: testCmdInfoMap()}
+
+	for _, cluster := range []bool{true, false} {
+		config.Redis.ClusterEnabled = cluster
+		for key, taggable := range map[string]bool{"user:1": true, "{user:1}}": true, "a}b": false} {
+			req := &proxy.Request{Cmd: "set", Args: args(key, "v"), IdempotencyKey: "req1"}
+			if _, _, err := s.validate(req); (err == nil) != (taggable || !cluster) {
+				t.Errorf("cluster %v: validate(%q) = %v", cluster, key, err)
+			}
+		}
+	}
+}
+
+func TestAllowedCommands(t *testing.T) {
+	cmdInfoMap := testCmdInfoMap()
+	cmdInfoMap["get"] = &redis.CommandInfo{Name: "get", Arity: 2, FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1, ReadOnly: true}
+	cmdInfoMap["keys"] = &redis.CommandInfo{Name: "keys", Arity: 2, ReadOnly: true}
+
+	writes, reads := allowedCommands(cmdInfoMap, map[string]bool{"set": true, "get": true})
+
+	if len(writes) != 1 || writes["set"] == nil {
+		t.Errorf("writes = %v, want set only", writes)
+	}
+	if len(reads) != 1 || reads["get"] == nil {
+		t.Errorf("reads = %v, want get only", reads)
+	}
+}
+
+// TestReadCmdClient checks the read-only commands are read from the route of their keys
+func TestReadCmdClient(t *testing.T) {
+	defer func(sinks []*config.SinkConfig) {
+		config.Sinks = sinks
+		route.Init(nil)
+	}(config.Sinks)
+
+	config.Sinks = []*config.SinkConfig{
+		{Name: config.DefaultSink, Enabled: true, Redis: &config.RedisConfig{Config: &rdb.Config{Addrs: []string{"127.0.0.1:6379"}}}},
+	}
+	err := route.Init([]*route.Rule{
+		{Name: "a", Kind: route.KindPrefix, Pattern: "a:", Target: config.DefaultSink, DB: 1},
+		{Name: "b", Kind: route.KindPrefix, Pattern: "b:", Target: config.DefaultSink, DB: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &proxyImpl{readCmds: map[string]*redis.CommandInfo{
+		"get":    {Name: "get", Arity: 2, FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1, ReadOnly: true},
+		"mget":   {Name: "mget", Arity: -2, FirstKeyPos: 1, LastKeyPos: -1, StepCount: 1, ReadOnly: true},
+		"dbsize": {Name: "dbsize", Arity: 1, ReadOnly: true},
+	}}
+	s.initReadClients()
+	defer func() {
+		for _, client := range s.ownClients {
+			client.Close()
+		}
+	}()
+
+	client, err := s.readCmdClient("get", args("b:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db := client.(*redis.Client).Options().DB; db != 2 {
+		t.Errorf("db = %v, want 2", db)
+	}
+
+	for _, tt := range []struct {
+		cmd  string
+		args [][]byte
+	}{
+		{cmd: "mget", args: args("a:1", "b:1")},
+		{cmd: "get", args: args("x:1")},
+		{cmd: "dbsize"},
+	} {
+		if _, err := s.readCmdClient(tt.cmd, tt.args); err == nil {
+			t.Errorf("readCmdClient(%v %v) succeeded, want error", tt.cmd, tt.args)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package proxysrv
 
 import (
+	"context"
 	"net"
 	"path"
 	"sync"
@@ -11,6 +12,8 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/stn81/nec/config"
 	"github.com/stn81/nec/proto/proxy"
@@ -23,6 +26,7 @@ type proxyService struct {
 	upgrader     *tableflip.Upgrader
 	listener     net.Listener
 	server       *grpc.Server
+	resp         *respServer
 	proxy        *proxyImpl
 	wg           sync.WaitGroup
 	logger       *zap.Logger
@@ -50,6 +54,22 @@ func Stop() {
 	}
 }
 
+// Do proxies the write request as the grpc Proxy.Do, for the other front ends
+func Do(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+	if gService == nil || gService.proxy == nil {
+		return nil, status.Error(codes.Unavailable, "proxy not started")
+	}
+	return gService.proxy.Do(ctx, req)
+}
+
+// DoBatch proxies the write requests as the grpc Proxy.DoBatch, for the other front ends
+func DoBatch(ctx context.Context, batch *proxy.BatchRequest) (*proxy.BatchResponse, error) {
+	if gService == nil || gService.proxy == nil {
+		return nil, status.Error(codes.Unavailable, "proxy not started")
+	}
+	return gService.proxy.DoBatch(ctx, batch)
+}
+
 func (s *proxyService) start() {
 	loggerCfg := zap.NewProductionEncoderConfig()
 	loggerCfg.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -90,6 +110,21 @@ func (s *proxyService) start() {
 	s.server = grpc.NewServer()
 	proxy.RegisterProxyServer(s.server, s.proxy)
 
+	if s.conf.RESPAddr != "" {
+		listener, err := s.upgrader.Listen("tcp", s.conf.RESPAddr)
+		if err != nil {
+			s.logger.Fatal("resp listen failed",
+				zap.String("addr", s.conf.RESPAddr),
+				zap.Error(err),
+			)
+		}
+
+		s.resp = newRESPServer(s.proxy, listener, s.conf.RESPReply, s.conf.RESPReadThrough, s.logger)
+		s.resp.wg.Add(1)
+		go s.resp.serve()
+		s.logger.Info("resp service started listening", zap.String("addr", s.conf.RESPAddr))
+	}
+
 	gService.wg.Add(1)
 	go gService.serve()
 }
@@ -108,6 +143,9 @@ func (s *proxyService) serve() {
 }
 
 func (s *proxyService) stop() {
+	if s.resp != nil {
+		s.resp.stop()
+	}
 	s.server.GracefulStop()
 	s.wg.Wait()
 
@@ -0,0 +1,247 @@
+package proxysrv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// respServer serves the redis protocol, so that a redis client writes through the proxy
+// as Proxy.Do. The writes are answered once in kafka, and the read-only commands are passed
+// through to redis if enabled.
+type respServer struct {
+	proxy       *proxyImpl
+	listener    net.Listener
+	reply       string
+	readThrough bool
+	mu          sync.Mutex
+	conns       map[net.Conn]struct{}
+	closed      bool
+	wg          sync.WaitGroup
+	logger      *zap.Logger
+}
+
+func newRESPServer(proxy *proxyImpl, listener net.Listener, reply string, readThrough bool, logger *zap.Logger) *respServer {
+	return &respServer{
+		proxy:       proxy,
+		listener:    listener,
+		reply:       strings.ToUpper(reply),
+		readThrough: readThrough,
+		conns:       make(map[net.Conn]struct{}),
+		logger:      logger,
+	}
+}
+
+func (s *respServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			s.logger.Error("failed to accept resp connection", zap.Error(err))
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// stop stops accepting, and closes the connections after their current command
+func (s *respServer) stop() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseRead()
+		} else {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *respServer) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	var (
+		rd = &respReader{r: bufio.NewReader(conn)}
+		wr = &respWriter{w: bufio.NewWriter(conn), proto: 2}
+	)
+
+	for {
+		args, err := rd.readCommand()
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				wr.writeError("ERR " + err.Error())
+				wr.w.Flush()
+			} else if err != io.EOF {
+				s.logger.Debug("resp connection closed", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+			}
+			return
+		}
+
+		if len(args) > 0 && !s.handle(wr, args) {
+			wr.w.Flush()
+			return
+		}
+
+		// flush once the pipelined commands are all handled
+		if rd.r.Buffered() == 0 {
+			if err = wr.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle handles a command, false is returned to close the connection
+func (s *respServer) handle(wr *respWriter, args [][]byte) bool {
+	cmd := strings.ToLower(string(args[0]))
+
+	switch cmd {
+	case "ping":
+		if len(args) > 1 {
+			wr.writeBulk(args[1])
+		} else {
+			wr.writeStatus("PONG")
+		}
+	case "echo":
+		if len(args) != 2 {
+			wr.writeError("ERR wrong number of arguments for 'echo' command")
+		} else {
+			wr.writeBulk(args[1])
+		}
+	case "hello":
+		s.hello(wr, args)
+	case "select":
+		if len(args) != 2 || string(args[1]) != "0" {
+			wr.writeError("ERR only db 0 is supported")
+		} else {
+			wr.writeStatus("OK")
+		}
+	case "client":
+		// CLIENT SETNAME and SETINFO of the client libraries
+		wr.writeStatus("OK")
+	case "command":
+		wr.writeArrayLen(0)
+	case "quit":
+		wr.writeStatus("OK")
+		return false
+	case "multi", "exec", "discard", "watch", "subscribe", "psubscribe", "auth":
+		wr.writeError("ERR command not supported by nec: " + cmd)
+	default:
+		if s.readThrough && s.proxy.readCmds[cmd] != nil {
+			s.read(wr, cmd, args)
+		} else {
+			s.write(wr, cmd, args)
+		}
+	}
+	return true
+}
+
+// hello switches the protocol version, and replies the server info
+func (s *respServer) hello(wr *respWriter, args [][]byte) {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil || proto < 2 || proto > 3 {
+			wr.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		wr.proto = proto
+	}
+
+	wr.writeMapLen(4)
+	wr.writeBulk([]byte("server"))
+	wr.writeBulk([]byte("nec"))
+	wr.writeBulk([]byte("proto"))
+	wr.writeInt(int64(wr.proto))
+	wr.writeBulk([]byte("mode"))
+	wr.writeBulk([]byte("standalone"))
+	wr.writeBulk([]byte("role"))
+	wr.writeBulk([]byte("master"))
+}
+
+// write enqueues the write command to kafka as Proxy.Do
+func (s *respServer) write(wr *respWriter, cmd string, args [][]byte) {
+	req := &proxy.Request{Cmd: cmd, Args: args[1:]}
+
+	resp, err := s.proxy.Do(context.Background(), req)
+	switch {
+	case err != nil:
+		wr.writeError("ERR " + status.Convert(err).Message())
+	case resp.Errno == proxy.Error_OK, resp.Errno == proxy.Error_COALESCED, resp.Errno == proxy.Error_SPOOLED:
+		wr.writeStatus(s.reply)
+	case resp.Errno == proxy.Error_CROSSSLOT:
+		wr.writeError("CROSSSLOT Keys in request don't hash to the same slot")
+	case resp.Errno == proxy.Error_RATELIMIT:
+		wr.writeError("BUSY " + resp.Message)
+	default:
+		wr.writeError("ERR " + resp.Message)
+	}
+}
+
+// read passes the read-only command through to the redis of its keys, rate limited as writes
+func (s *respServer) read(wr *respWriter, cmd string, args [][]byte) {
+	if !s.proxy.tokenBucket.WaitMaxDuration(1, time.Millisecond*100) {
+		wr.writeError("BUSY " + errRateLimitReached.Error())
+		return
+	}
+
+	client, err := s.proxy.readCmdClient(cmd, args[1:])
+	if err != nil {
+		wr.writeError("ERR " + status.Convert(err).Message())
+		return
+	}
+
+	cmdArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg
+	}
+
+	value, err := client.Do(cmdArgs...).Result()
+	switch {
+	case err == redis.Nil:
+		wr.writeNull()
+	case err != nil:
+		wr.writeError(err.Error())
+	default:
+		wr.writeValue(value)
+	}
+}
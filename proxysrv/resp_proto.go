@@ -0,0 +1,160 @@
+package proxysrv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxRESPArgs = 1024 * 1024
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// respReader reads the commands of the redis protocol, the multibulk and inline ones
+type respReader struct {
+	r *bufio.Reader
+}
+
+// readCommand reads a command, an empty command is returned for an empty line
+func (rd *respReader) readCommand() ([][]byte, error) {
+	line, err := rd.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		// the line is in the buffer of reader, overwritten by the next read
+		return bytes.Fields(append([]byte(nil), line...)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+
+	// an empty command as redis does for the null or empty multibulk
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// the args are appended as read, not trusting the length claimed by the client
+	args := make([][]byte, 0, minInt(n, 64))
+	for i := 0; i < n; i++ {
+		if line, err = rd.readLine(); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errRESPProtocol, line)
+		}
+
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > MaxReqSize {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(rd.r, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk not terminated by CRLF", errRESPProtocol)
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// readLine reads a line without the CRLF
+func (rd *respReader) readLine() ([]byte, error) {
+	line, err := rd.r.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull:
+		return nil, fmt.Errorf("%w: too big inline request", errRESPProtocol)
+	case err != nil:
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// respWriter writes the replies of the redis protocol, RESP3 if proto is 3
+type respWriter struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func (wr *respWriter) writeStatus(status string) {
+	wr.w.WriteString("+" + status + "\r\n")
+}
+
+// respErrorCleaner replaces the newlines in the error messages, which may carry client bytes,
+// as redis does, to keep the framing
+var respErrorCleaner = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (wr *respWriter) writeError(msg string) {
+	wr.w.WriteString("-" + respErrorCleaner.Replace(msg) + "\r\n")
+}
+
+func (wr *respWriter) writeInt(n int64) {
+	wr.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (wr *respWriter) writeBulk(b []byte) {
+	wr.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	wr.w.Write(b)
+	wr.w.WriteString("\r\n")
+}
+
+func (wr *respWriter) writeNull() {
+	if wr.proto == 3 {
+		wr.w.WriteString("_\r\n")
+		return
+	}
+	wr.w.WriteString("$-1\r\n")
+}
+
+func (wr *respWriter) writeArrayLen(n int) {
+	wr.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMapLen writes the header of a map with n pairs, a flat array in RESP2
+func (wr *respWriter) writeMapLen(n int) {
+	if wr.proto == 3 {
+		wr.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	wr.writeArrayLen(n * 2)
+}
+
+// writeValue writes a reply of go-redis
+func (wr *respWriter) writeValue(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		wr.writeNull()
+	case string:
+		wr.writeBulk([]byte(v))
+	case []byte:
+		wr.writeBulk(v)
+	case int64:
+		wr.writeInt(v)
+	case []interface{}:
+		wr.writeArrayLen(len(v))
+		for _, item := range v {
+			wr.writeValue(item)
+		}
+	case error:
+		wr.writeError(v.Error())
+	default:
+		wr.writeBulk([]byte(fmt.Sprint(v)))
+	}
+}
@@ -0,0 +1,85 @@
+package proxysrv
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRESPReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   bool
+	}{
+		{name: "multibulk", input: "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n", want: []string{"set", "k", "v"}},
+		{name: "empty bulk", input: "*2\r\n$4\r\necho\r\n$0\r\n\r\n", want: []string{"echo", ""}},
+		{name: "inline", input: "set k  v\r\n", want: []string{"set", "k", "v"}},
+		{name: "inline lf", input: "ping\n", want: []string{"ping"}},
+		{name: "empty line", input: "\r\n"},
+		{name: "null multibulk", input: "*-1\r\n"},
+		{name: "negative multibulk", input: "*-100\r\n"},
+		{name: "zero multibulk", input: "*0\r\n"},
+		{name: "oversized multibulk", input: "*1048577\r\n", err: true},
+		{name: "invalid multibulk", input: "*x\r\n", err: true},
+		{name: "negative bulk", input: "*1\r\n$-1\r\n", err: true},
+		{name: "oversized bulk", input: "*1\r\n$2097152\r\n", err: true},
+		{name: "not bulk", input: "*1\r\n:1\r\n", err: true},
+		{name: "missing crlf", input: "*1\r\n$3\r\nsetXY", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := &respReader{r: bufio.NewReader(strings.NewReader(tt.input))}
+
+			args, err := rd.readCommand()
+			if tt.err {
+				if !errors.Is(err, errRESPProtocol) {
+					t.Fatalf("err = %v, want protocol error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+
+			var got []string
+			for _, arg := range args {
+				got = append(got, string(arg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRESPReadCommandAfterEmpty(t *testing.T) {
+	rd := &respReader{r: bufio.NewReader(strings.NewReader("*-1\r\n*0\r\n*1\r\n$4\r\nping\r\n"))}
+
+	for i := 0; i < 2; i++ {
+		if args, err := rd.readCommand(); err != nil || len(args) != 0 {
+			t.Fatalf("args = %q, err = %v, want empty", args, err)
+		}
+	}
+
+	args, err := rd.readCommand()
+	if err != nil || len(args) != 1 || string(args[0]) != "ping" {
+		t.Fatalf("args = %q, err = %v, want ping", args, err)
+	}
+}
+
+// TestRESPWriteError checks the newlines in the error message don't break the framing
+func TestRESPWriteError(t *testing.T) {
+	var buf strings.Builder
+	wr := &respWriter{w: bufio.NewWriter(&buf)}
+	wr.writeError("ERR key matches no route: a\r\n+OK")
+	wr.w.Flush()
+
+	if got, want := buf.String(), "-ERR key matches no route: a  +OK\r\n"; got != want {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+}
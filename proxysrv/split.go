@@ -0,0 +1,258 @@
+package proxysrv
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/stn81/nec/common/hashtag"
+	"github.com/stn81/nec/common/partitioner"
+	"github.com/stn81/nec/common/route"
+	"github.com/stn81/nec/config"
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// unsplittable are the multi-key commands whose semantics depend on seeing all the keys
+var unsplittable = map[string]bool{
+	"msetnx": true,
+}
+
+// part is one kafka message of a checked request. A multi-key write is split into parts
+// of one key each, or one slot each when partitioned by slot.
+type part struct {
+	cmd     string
+	req     *proxy.Request
+	keys    [][]byte
+	message *sarama.ProducerMessage
+	resp    *proxy.Response
+	err     error
+}
+
+// split expands the multi-key write into the sub-commands of each key or slot
+func (s *proxyImpl) split(req *proxy.Request, cmd string, keys [][]byte) []*part {
+	cmdInfo := s.cmdInfoMap[cmd]
+	if len(keys) <= 1 || cmd == cmdMulti || !splittable(cmd, cmdInfo) {
+		return []*part{{cmd: cmd, req: req, keys: keys}}
+	}
+
+	var (
+		step   = stepCount(cmdInfo)
+		first  = int(cmdInfo.FirstKeyPos) - 1
+		groups = make(map[string]*part)
+		parts  []*part
+	)
+
+	for i, key := range keys {
+		groupKey := string(key)
+		if config.Proxy.Partitioner == partitioner.ModeSlot {
+			// the keys of a slot are applied together, so they must share the route too
+			groupKey = strconv.Itoa(hashtag.Slot(string(key)))
+			if rule := route.Get().Match(string(key)); rule != nil {
+				groupKey += "/" + rule.Name
+			}
+		}
+
+		p, ok := groups[groupKey]
+		if !ok {
+			p = &part{
+				cmd: cmd,
+				req: &proxy.Request{Cmd: req.Cmd, DeadlineMs: req.DeadlineMs, Version: req.Version, ApplyAtMs: req.ApplyAtMs},
+			}
+			if req.IdempotencyKey != "" {
+				p.req.IdempotencyKey = fmt.Sprintf("%s#%d", req.IdempotencyKey, len(parts))
+			}
+			groups[groupKey] = p
+			parts = append(parts, p)
+		}
+
+		pos := first + i*step
+		p.req.Args = append(p.req.Args, req.Args[pos:pos+step]...)
+		p.keys = append(p.keys, key)
+	}
+
+	return parts
+}
+
+// splittable returns whether the args of the command are all key groups, like DEL and MSET
+func splittable(cmd string, cmdInfo *redis.CommandInfo) bool {
+	return cmdInfo.FirstKeyPos == 1 && cmdInfo.LastKeyPos == -1 && !hasFlag(cmdInfo, "movablekeys") && !unsplittable[cmd]
+}
+
+// commandKeys returns the keys in the args of the command
+func commandKeys(cmd string, cmdInfo *redis.CommandInfo, args [][]byte) ([][]byte, error) {
+	if cmdInfo.FirstKeyPos <= 0 || hasFlag(cmdInfo, "movablekeys") {
+		return movableKeys(cmd, args)
+	}
+
+	first := int(cmdInfo.FirstKeyPos)
+
+	// the positions count the command name as 0, and a negative last position is
+	// counted backward from the end of args.
+	last := int(cmdInfo.LastKeyPos)
+	if last < 0 {
+		last += len(args) + 1
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+
+	step := stepCount(cmdInfo)
+	if (last-first+1)%step != 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid redis command arity")
+	}
+
+	keys := make([][]byte, 0, (last-first)/step+1)
+	for pos := first; pos <= last; pos += step {
+		keys = append(keys, args[pos-1])
+	}
+	return keys, nil
+}
+
+// movableKeys parses the keys of the commands whose keys are not at fixed positions
+func movableKeys(cmd string, args [][]byte) ([][]byte, error) {
+	var keys [][]byte
+
+	switch cmd {
+	case "eval", "evalsha":
+		// script numkeys key [key ...] arg [arg ...]
+		numKeys, err := parseNumKeys(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		keys = args[2 : 2+numKeys]
+	case "zunionstore", "zinterstore":
+		// destination numkeys key [key ...] [WEIGHTS ...] [AGGREGATE ...]
+		numKeys, err := parseNumKeys(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		keys = append([][]byte{args[0]}, args[2:2+numKeys]...)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "redis command without fixed key positions not supported")
+	}
+
+	return keys, nil
+}
+
+func parseNumKeys(args [][]byte, pos int) (int, error) {
+	numKeys, err := strconv.Atoi(string(args[pos]))
+	if err != nil || numKeys < 0 || pos+1+numKeys > len(args) {
+		return 0, status.Error(codes.InvalidArgument, "invalid numkeys")
+	}
+	return numKeys, nil
+}
+
+// sameSlot returns whether the keys are all in one slot
+func sameSlot(keys [][]byte) bool {
+	for i := 1; i < len(keys); i++ {
+		if hashtag.Slot(string(keys[i])) != hashtag.Slot(string(keys[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+func stepCount(cmdInfo *redis.CommandInfo) int {
+	if cmdInfo.StepCount <= 0 {
+		return 1
+	}
+	return int(cmdInfo.StepCount)
+}
+
+func hasFlag(cmdInfo *redis.CommandInfo, flag string) bool {
+	for _, f := range cmdInfo.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeResponses aggregates the responses of the parts of a request. The errno is the
+// first failure of the parts, or SPOOLED if any part spooled and none failed, and the
+// partition/offset is the one of the first part.
+func mergeResponses(parts []*part) *proxy.Response {
+	if len(parts) == 1 {
+		return parts[0].resp
+	}
+
+	merged := &proxy.Response{
+		Partition: parts[0].resp.Partition,
+		Offset:    parts[0].resp.Offset,
+		Parts:     make([]*proxy.Response, 0, len(parts)),
+	}
+
+	for _, p := range parts {
+		if isFailure(p.resp.Errno) && !isFailure(merged.Errno) || p.resp.Errno == proxy.Error_SPOOLED && merged.Errno == proxy.Error_OK {
+			merged.Errno = p.resp.Errno
+			merged.Message = p.resp.Message
+		}
+		merged.Parts = append(merged.Parts, p.resp)
+	}
+
+	return merged
+}
+
+// isFailure returns whether the errno is a failure, the coalesced and spooled writes are accepted
+func isFailure(errno proxy.Error) bool {
+	switch errno {
+	case proxy.Error_OK, proxy.Error_COALESCED, proxy.Error_SPOOLED:
+		return false
+	}
+	return true
+}
+
+// checkRoutes checks every key matches a route if routing enabled, and the keys applied
+// together share the route target.
+func checkRoutes(keys [][]byte, together bool) error {
+	table := route.Get()
+	if !table.Enabled() {
+		return nil
+	}
+
+	var first *route.Rule
+	for _, key := range keys {
+		rule := table.Match(string(key))
+		switch {
+		case rule == nil:
+			return status.Errorf(codes.InvalidArgument, "key matches no route: %s", key)
+		case first == nil:
+			first = rule
+		case together && !rule.Same(first):
+			return status.Error(codes.InvalidArgument, "keys span routes")
+		}
+	}
+	return nil
+}
+
+// checkTaggable checks the idempotency key of the write can share the slot of each key, by
+// the hashtag of it, which the consumer requires to apply them atomically on redis cluster.
+func checkTaggable(req *proxy.Request, keys [][]byte) error {
+	if req.IdempotencyKey == "" || !clusterSinks() {
+		return nil
+	}
+
+	for _, key := range keys {
+		if _, ok := hashtag.Tagged("", string(key)); !ok {
+			return status.Error(codes.InvalidArgument, "idempotency key with a key not taggable on redis cluster")
+		}
+	}
+	return nil
+}
+
+// clusterSinks returns whether any sink or route target is a redis cluster
+func clusterSinks() bool {
+	if config.Redis.ClusterEnabled {
+		return true
+	}
+	for _, sink := range config.Sinks {
+		if sink.Redis.ClusterEnabled {
+			return true
+		}
+	}
+	return false
+}
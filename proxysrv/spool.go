@@ -0,0 +1,803 @@
+package proxysrv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/config"
+)
+
+const (
+	spoolSegmentExt   = ".seg"
+	spoolCursorFile   = "cursor"
+	spoolLockFile     = "lock"
+	spoolHeaderSize   = 8 // len(4) + crc32(4) of the body
+	spoolBodyMinSize  = 12
+	spoolDrainBatch   = 100
+	spoolCursorEvery  = 1000
+	spoolRateInterval = 10 * time.Second
+)
+
+var (
+	errSpoolFull    = errors.New("spool full")
+	errSpoolLocked  = errors.New("spool locked by another process")
+	errSpoolCorrupt = errors.New("spool record corrupt")
+)
+
+// spoolRecord is a kafka message in the spool, the body on disk is ts(8) keyLen(4) key value
+type spoolRecord struct {
+	size  int64
+	ts    time.Time
+	key   []byte
+	value []byte
+}
+
+// spoolSegment is a segment file of the spool
+type spoolSegment struct {
+	seq     int64
+	size    int64
+	records int64
+}
+
+// spool is the local disk write-ahead spool of the kafka messages which can't be produced while
+// kafka is unavailable. The messages are appended to the segment files <seq>.seg, and the drainer
+// replays them to kafka in order once it recovers, the drained position is saved in the cursor
+// file. The messages drained but not saved in the cursor are produced again after a restart.
+//
+// The directory is owned by one process by the flock of the lock file. On a zero-downtime
+// restart the new process opens the spool while the old one still serves from it, so the new
+// spool rejects the appends and waits for the lock, which the old one releases when stopped,
+// then recovers the segments left and drains them.
+type spool struct {
+	conf   config.SpoolConfig
+	dir    string
+	send   func(batch []*spoolRecord) error
+	logger *zap.Logger
+
+	lock      *os.File
+	mu        sync.Mutex
+	locked    bool
+	segments  []*spoolSegment // oldest first, the last one is appended
+	writer    *os.File
+	nextSeq   int64
+	readOff   int64 // offset of the first segment to drain
+	records   int64
+	bytes     int64
+	head      time.Time // timestamp of the oldest record to drain
+	full      bool
+	unsaved   int
+	rate      float64
+	rateBegin time.Time
+	rateCount int64
+
+	reader    *os.File
+	readerSeq int64
+	wake      chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	appended prometheus.Counter
+	drained  prometheus.Counter
+	rejected prometheus.Counter
+}
+
+// openSpool opens the spool in dir, recovering the segments left by the previous run.
+// The torn tail of a segment is truncated. If the directory is locked by another process,
+// the recovery is deferred to the drainer once the lock is taken.
+func openSpool(conf config.SpoolConfig, dir string, send func(batch []*spoolRecord) error, logger *zap.Logger) (*spool, error) {
+	s := &spool{
+		conf:      conf,
+		dir:       dir,
+		send:      send,
+		logger:    logger,
+		readerSeq: -1,
+		rateBegin: time.Now(),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, spoolLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.lock = lock
+
+	if s.locked, err = s.tryLock(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	if s.locked {
+		if err = s.recover(); err != nil {
+			lock.Close()
+			return nil, err
+		}
+	} else {
+		logger.Warn("spool locked by another process, waiting", zap.String("dir", dir))
+	}
+
+	s.appended = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_spool_appended_total",
+		Help: "The total number of messages spooled by proxy",
+	})
+	s.drained = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_spool_drained_total",
+		Help: "The total number of spooled messages produced to kafka",
+	})
+	s.rejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_spool_rejected_total",
+		Help: "The total number of messages rejected by the spool full or locked",
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_spool_records",
+		Help: "The number of messages in the spool",
+	}, func() float64 {
+		records, _, _, _ := s.stats()
+		return float64(records)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_spool_bytes",
+		Help: "The bytes of messages in the spool",
+	}, func() float64 {
+		_, bytes, _, _ := s.stats()
+		return float64(bytes)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_spool_oldest_age_seconds",
+		Help: "The age of the oldest message in the spool",
+	}, func() float64 {
+		_, _, age, _ := s.stats()
+		return age.Seconds()
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_spool_drain_rate",
+		Help: "The spooled messages produced to kafka per second",
+	}, func() float64 {
+		_, _, _, rate := s.stats()
+		return rate
+	})
+
+	return s, nil
+}
+
+// tryLock takes the flock of the directory, returns false if held by another process
+func (s *spool) tryLock() (bool, error) {
+	err := syscall.Flock(int(s.lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitLock waits for the lock released by the other process and recovers the spool,
+// returns false if stopped
+func (s *spool) waitLock() bool {
+	for {
+		locked, err := s.tryLock()
+		if err != nil {
+			s.logger.Error("spool lock failed", zap.Error(err))
+		}
+
+		if locked {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if err = s.recover(); err != nil {
+				// keep the lock, so no other process opens the spool not recovered
+				s.logger.Error("spool recover failed", zap.Error(err))
+				return false
+			}
+			s.locked = true
+			s.logger.Info("spool lock taken", zap.String("dir", s.dir))
+			return true
+		}
+
+		if !s.backoff() {
+			return false
+		}
+	}
+}
+
+// recover loads the segments and the cursor, dropping the drained segments
+func (s *spool) recover() error {
+	cursorSeq, cursorOff, err := s.loadCursor()
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var seqs []int64
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	s.nextSeq = cursorSeq + 1
+
+	for _, seq := range seqs {
+		if seq < cursorSeq {
+			if err = os.Remove(s.segmentPath(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var begin int64
+		if seq == cursorSeq {
+			begin = cursorOff
+		}
+
+		seg, err := s.scanSegment(seq, begin)
+		if err != nil {
+			return err
+		}
+
+		if len(s.segments) == 0 {
+			s.readOff = begin
+		}
+
+		s.segments = append(s.segments, seg)
+		s.records += seg.records
+		s.bytes += seg.size - begin
+		s.nextSeq = seq + 1
+	}
+
+	if len(s.segments) > 0 {
+		last := s.segments[len(s.segments)-1]
+		if s.writer, err = os.OpenFile(s.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	}
+
+	if s.records > 0 {
+		s.logger.Info("spool recovered",
+			zap.Int("segments", len(s.segments)),
+			zap.Int64("records", s.records),
+			zap.Int64("bytes", s.bytes),
+		)
+	}
+	return nil
+}
+
+// scanSegment counts the records of the segment from the offset begin, and truncates the torn tail.
+// The head is set to the first record counted.
+func (s *spool) scanSegment(seq, begin int64) (*spoolSegment, error) {
+	path := s.segmentPath(seq)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seg := &spoolSegment{seq: seq}
+	r := bufio.NewReader(file)
+
+	for {
+		rec, err := readSpoolRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.logger.Error("spool segment tail truncated",
+				zap.String("segment", path),
+				zap.Int64("size", seg.size),
+				zap.Error(err),
+			)
+			if err = os.Truncate(path, seg.size); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		if seg.size >= begin {
+			if s.head.IsZero() {
+				s.head = rec.ts
+			}
+			seg.records++
+		}
+		seg.size += rec.size
+	}
+
+	return seg, nil
+}
+
+func (s *spool) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// Start starts the drainer
+func (s *spool) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop stops the drainer, saves the cursor and releases the lock
+func (s *spool) Stop() {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked {
+		s.saveCursor()
+	}
+
+	if s.writer != nil {
+		s.writer.Close()
+	}
+	if s.reader != nil {
+		s.reader.Close()
+	}
+	s.lock.Close()
+}
+
+// pending returns whether there are messages not yet drained, the new messages must be spooled
+// behind them to keep the order.
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records > 0
+}
+
+// append spools the message. The appends are serialized, and synced to disk if fsync enabled.
+func (s *spool) append(key, value []byte) error {
+	buf := encodeSpoolRecord(time.Now(), key, value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.locked {
+		s.rejected.Inc()
+		return errSpoolLocked
+	}
+
+	if s.bytes+int64(len(buf)) > s.conf.MaxBytes {
+		s.full = true
+		s.rejected.Inc()
+		return errSpoolFull
+	}
+
+	if len(s.segments) == 0 || s.segments[len(s.segments)-1].size+int64(len(buf)) > s.conf.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	seg := s.segments[len(s.segments)-1]
+
+	if _, err := s.writer.Write(buf); err != nil {
+		s.writer.Truncate(seg.size)
+		return err
+	}
+
+	if s.conf.Fsync {
+		if err := s.writer.Sync(); err != nil {
+			s.writer.Truncate(seg.size)
+			return err
+		}
+	}
+
+	if s.records == 0 {
+		s.head = time.Now()
+	}
+
+	seg.size += int64(len(buf))
+	seg.records++
+	s.records++
+	s.bytes += int64(len(buf))
+	s.appended.Inc()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate creates a new segment to append
+func (s *spool) rotate() error {
+	if len(s.segments) > 0 && s.segments[len(s.segments)-1].size == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.segmentPath(s.nextSeq), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if s.conf.Fsync {
+		if err = syncDir(s.dir); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if s.writer != nil {
+		s.writer.Close()
+	}
+
+	s.writer = file
+	s.segments = append(s.segments, &spoolSegment{seq: s.nextSeq})
+	s.nextSeq++
+	return nil
+}
+
+func (s *spool) loop() {
+	defer s.wg.Done()
+
+	s.mu.Lock()
+	locked := s.locked
+	s.mu.Unlock()
+
+	if !locked && !s.waitLock() {
+		return
+	}
+
+	for {
+		batch, err := s.next()
+		switch {
+		case err == io.EOF:
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		case err != nil:
+			s.logger.Error("spool read failed", zap.Error(err))
+			if !s.backoff() {
+				return
+			}
+			continue
+		}
+
+		for {
+			if err = s.send(batch); err == nil {
+				break
+			}
+
+			s.logger.Warn("spool drain to kafka failed",
+				zap.Int("batch", len(batch)),
+				zap.Error(err),
+			)
+			if !s.backoff() {
+				return
+			}
+		}
+
+		s.advance(batch)
+	}
+}
+
+// backoff waits for the retry interval, returns false if stopped
+func (s *spool) backoff() bool {
+	select {
+	case <-time.After(s.conf.RetryInterval):
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// next reads the next batch of records to drain from the first segment, the drained segments
+// are removed. io.EOF is returned if no record to drain.
+func (s *spool) next() ([]*spoolRecord, error) {
+	s.mu.Lock()
+
+	var seg *spoolSegment
+	for {
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil, io.EOF
+		}
+
+		seg = s.segments[0]
+		if s.readOff < seg.size {
+			break
+		}
+
+		if len(s.segments) == 1 {
+			s.mu.Unlock()
+			return nil, io.EOF
+		}
+
+		s.removeFirst()
+	}
+
+	seq, begin, end := seg.seq, s.readOff, seg.size
+	s.mu.Unlock()
+
+	if s.readerSeq != seq {
+		if s.reader != nil {
+			s.reader.Close()
+			s.reader = nil
+		}
+
+		reader, err := os.Open(s.segmentPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.reader, s.readerSeq = reader, seq
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(s.reader, begin, end-begin))
+
+	batch := make([]*spoolRecord, 0, spoolDrainBatch)
+	for len(batch) < spoolDrainBatch {
+		rec, err := readSpoolRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.skip(seg, err)
+			return nil, err
+		}
+		batch = append(batch, rec)
+	}
+
+	s.mu.Lock()
+	s.head = batch[0].ts
+	s.mu.Unlock()
+
+	return batch, nil
+}
+
+// removeFirst removes the drained first segment
+func (s *spool) removeFirst() {
+	seg := s.segments[0]
+	s.segments = s.segments[1:]
+	s.readOff = 0
+
+	if s.readerSeq == seg.seq {
+		s.reader.Close()
+		s.reader, s.readerSeq = nil, -1
+	}
+
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil {
+		s.logger.Error("spool remove segment failed", zap.Int64("segment", seg.seq), zap.Error(err))
+	}
+	s.saveCursor()
+}
+
+// skip drops the rest of the segment which can't be read
+func (s *spool) skip(seg *spoolSegment, cause error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logger.Error("spool segment skipped",
+		zap.Int64("segment", seg.seq),
+		zap.Int64("offset", s.readOff),
+		zap.Int64("records", seg.records),
+		zap.Error(cause),
+	)
+
+	s.records -= seg.records
+	s.bytes -= seg.size - s.readOff
+	s.readOff = seg.size
+	seg.records = 0
+}
+
+// advance moves the cursor past the drained batch
+func (s *spool) advance(batch []*spoolRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seg := s.segments[0]
+	for _, rec := range batch {
+		s.readOff += rec.size
+		s.bytes -= rec.size
+	}
+
+	n := int64(len(batch))
+	seg.records -= n
+	s.records -= n
+	s.full = false
+	s.drained.Add(float64(n))
+	s.rateCount += n
+	s.updateRate(time.Now())
+
+	if s.unsaved += len(batch); s.unsaved >= spoolCursorEvery || s.records == 0 {
+		s.saveCursor()
+	}
+}
+
+func (s *spool) updateRate(now time.Time) {
+	if elapsed := now.Sub(s.rateBegin); elapsed >= spoolRateInterval {
+		s.rate = float64(s.rateCount) / elapsed.Seconds()
+		s.rateBegin = now
+		s.rateCount = 0
+	}
+}
+
+// stats returns the depth, the oldest age and the drain rate of the spool
+func (s *spool) stats() (records, bytes int64, age time.Duration, rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.updateRate(now)
+
+	if s.records > 0 && !s.head.IsZero() {
+		age = now.Sub(s.head)
+	}
+	return s.records, s.bytes, age, s.rate
+}
+
+// Status returns the status of the spool for health check
+func (s *spool) Status() string {
+	records, bytes, age, rate := s.stats()
+
+	s.mu.Lock()
+	locked := s.locked
+	s.mu.Unlock()
+
+	if !locked {
+		return "waiting: " + errSpoolLocked.Error()
+	}
+	if records == 0 {
+		return "empty"
+	}
+
+	s.mu.Lock()
+	state := "draining"
+	if s.full {
+		state = "full"
+	}
+	s.mu.Unlock()
+
+	return fmt.Sprintf("%v: records=%v bytes=%v age=%v rate=%.1f/s",
+		state, records, bytes, age.Truncate(time.Second), rate)
+}
+
+// loadCursor reads the segment and offset drained
+func (s *spool) loadCursor() (seq, offset int64, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if _, err = fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		return 0, 0, fmt.Errorf("invalid spool cursor: %v", err)
+	}
+	return seq, offset, nil
+}
+
+// saveCursor saves the drain position, must be called with mu held
+func (s *spool) saveCursor() {
+	s.unsaved = 0
+
+	var seq int64
+	if len(s.segments) > 0 {
+		seq = s.segments[0].seq
+	} else {
+		seq = s.nextSeq - 1
+	}
+
+	path := filepath.Join(s.dir, spoolCursorFile)
+	data := fmt.Sprintf("%d %d\n", seq, s.readOff)
+
+	if err := writeFileAtomic(path, []byte(data), s.conf.Fsync); err != nil {
+		s.logger.Error("spool save cursor failed", zap.Error(err))
+	}
+}
+
+func writeFileAtomic(path string, data []byte, fsync bool) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil && fsync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func encodeSpoolRecord(ts time.Time, key, value []byte) []byte {
+	bodySize := spoolBodyMinSize + len(key) + len(value)
+	buf := make([]byte, spoolHeaderSize+bodySize)
+
+	body := buf[spoolHeaderSize:]
+	binary.BigEndian.PutUint64(body[0:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(body[8:12], uint32(len(key)))
+	copy(body[spoolBodyMinSize:], key)
+	copy(body[spoolBodyMinSize+len(key):], value)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(bodySize))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// readSpoolRecord reads a record, io.EOF is returned only at the record boundary
+func readSpoolRecord(r io.Reader) (*spoolRecord, error) {
+	var header [spoolHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	bodySize := binary.BigEndian.Uint32(header[0:4])
+	if bodySize < spoolBodyMinSize || bodySize > MaxReqSize*2 {
+		return nil, errSpoolCorrupt
+	}
+
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errSpoolCorrupt
+	}
+
+	keyLen := binary.BigEndian.Uint32(body[8:12])
+	if keyLen > bodySize-spoolBodyMinSize {
+		return nil, errSpoolCorrupt
+	}
+
+	return &spoolRecord{
+		size:  int64(spoolHeaderSize + bodySize),
+		ts:    time.Unix(0, int64(binary.BigEndian.Uint64(body[0:8]))),
+		key:   body[spoolBodyMinSize : spoolBodyMinSize+keyLen],
+		value: body[spoolBodyMinSize+keyLen:],
+	}, nil
+}
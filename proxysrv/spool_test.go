@@ -0,0 +1,288 @@
+package proxysrv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/stn81/nec/config"
+)
+
+var errKafkaDown = errors.New("kafka down")
+
+// spoolSink records the drained keys, failing the batches after the limit
+type spoolSink struct {
+	mu      sync.Mutex
+	keys    []string
+	batches int
+	limit   int // max batches accepted, -1 for no limit
+}
+
+func (k *spoolSink) send(batch []*spoolRecord) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.limit >= 0 && k.batches >= k.limit {
+		return errKafkaDown
+	}
+	k.batches++
+	for _, rec := range batch {
+		k.keys = append(k.keys, string(rec.key))
+	}
+	return nil
+}
+
+func (k *spoolSink) drained() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.keys...)
+}
+
+func (k *spoolSink) sent() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.batches
+}
+
+func testSpoolConfig() config.SpoolConfig {
+	return config.SpoolConfig{
+		MaxBytes:      1 << 20,
+		SegmentBytes:  512,
+		RetryInterval: 5 * time.Millisecond,
+		Fsync:         true,
+	}
+}
+
+func openTestSpool(t *testing.T, conf config.SpoolConfig, dir string, sink *spoolSink) *spool {
+	resetRegistry()
+	s, err := openSpool(conf, dir, sink.send, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func appendKeys(t *testing.T, s *spool, from, to int) []string {
+	var keys []string
+	for i := from; i < to; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		if err := s.append([]byte(key), []byte("value-of-"+key)); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			files = append(files, dir+"/"+entry.Name())
+		}
+	}
+	return files
+}
+
+func waitSpool(t *testing.T, cond func() bool) {
+	for i := 0; i < 2000; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestSpoolTornTail(t *testing.T) {
+	dir := t.TempDir()
+	sink := &spoolSink{limit: -1}
+
+	s := openTestSpool(t, testSpoolConfig(), dir, sink)
+	keys := appendKeys(t, s, 0, 5)
+	s.Stop()
+
+	files := segmentFiles(t, dir)
+	last := files[len(files)-1]
+	info, _ := os.Stat(last)
+
+	// a record header claiming more than written
+	file, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	file.Write([]byte{0, 0, 0, 64, 1, 2})
+	file.Close()
+
+	s = openTestSpool(t, testSpoolConfig(), dir, sink)
+	defer s.Stop()
+
+	if records, _, _, _ := s.stats(); records != 5 {
+		t.Fatalf("records = %v, want 5", records)
+	}
+	if truncated, _ := os.Stat(last); truncated.Size() != info.Size() {
+		t.Fatalf("size = %v, want truncated to %v", truncated.Size(), info.Size())
+	}
+
+	// appended after the truncated tail
+	keys = append(keys, appendKeys(t, s, 5, 6)...)
+
+	s.Start()
+	waitSpool(t, func() bool { return !s.pending() })
+
+	if got := sink.drained(); !reflect.DeepEqual(got, keys) {
+		t.Fatalf("drained = %v, want %v", got, keys)
+	}
+}
+
+func TestSpoolCursorResume(t *testing.T) {
+	dir := t.TempDir()
+	sink := &spoolSink{limit: 2}
+
+	s := openTestSpool(t, testSpoolConfig(), dir, sink)
+	keys := appendKeys(t, s, 0, 40)
+	if n := len(segmentFiles(t, dir)); n < 3 {
+		t.Fatalf("segments = %v, want rotated", n)
+	}
+
+	// the batches are of a segment each, the first is removed once the second is read
+	s.Start()
+	waitSpool(t, func() bool { return sink.sent() == 2 })
+	s.Stop()
+
+	drained := sink.drained()
+	sink.limit = -1
+
+	s = openTestSpool(t, testSpoolConfig(), dir, sink)
+	defer s.Stop()
+
+	if records, _, _, _ := s.stats(); records != int64(len(keys)-len(drained)) {
+		t.Fatalf("records = %v, want %v", records, len(keys)-len(drained))
+	}
+
+	s.Start()
+	waitSpool(t, func() bool { return !s.pending() })
+
+	if got := sink.drained(); !reflect.DeepEqual(got, keys) {
+		t.Fatalf("drained = %v, want %v in order without duplicates", got, keys)
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Fatalf("segments = %v, want the drained ones removed", n)
+	}
+}
+
+func TestSpoolSkipCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	sink := &spoolSink{limit: -1}
+
+	s := openTestSpool(t, testSpoolConfig(), dir, sink)
+	defer s.Stop()
+
+	keys := appendKeys(t, s, 0, 40)
+
+	// flip a byte of the first record body of the first segment
+	files := segmentFiles(t, dir)
+	file, _ := os.OpenFile(files[0], os.O_RDWR, 0)
+	file.WriteAt([]byte{0xff}, spoolHeaderSize+spoolBodyMinSize)
+	file.Close()
+
+	first := s.segments[0].records
+
+	s.Start()
+	waitSpool(t, func() bool { return !s.pending() })
+
+	if got := sink.drained(); !reflect.DeepEqual(got, keys[first:]) {
+		t.Fatalf("drained = %v, want %v", got, keys[first:])
+	}
+	if records, bytes, _, _ := s.stats(); records != 0 || bytes != 0 {
+		t.Fatalf("records = %v, bytes = %v, want empty", records, bytes)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir := t.TempDir()
+	sink := &spoolSink{limit: 0}
+
+	conf := testSpoolConfig()
+	conf.MaxBytes = 1024
+
+	s := openTestSpool(t, conf, dir, sink)
+	defer s.Stop()
+
+	var n int
+	for ; ; n++ {
+		err := s.append([]byte(fmt.Sprintf("k%03d", n)), []byte("value"))
+		if err == errSpoolFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, bytes, _, _ := s.stats(); bytes > conf.MaxBytes {
+		t.Fatalf("bytes = %v, over the max %v", bytes, conf.MaxBytes)
+	}
+	if status := s.Status(); !strings.HasPrefix(status, "full") {
+		t.Fatalf("status = %q, want full", status)
+	}
+
+	// accepted again once drained
+	sink.mu.Lock()
+	sink.limit = -1
+	sink.mu.Unlock()
+
+	s.Start()
+	waitSpool(t, func() bool { return !s.pending() })
+
+	if got := len(sink.drained()); got != n {
+		t.Fatalf("drained = %v, want %v", got, n)
+	}
+	if err := s.append([]byte("k"), []byte("value")); err != nil {
+		t.Fatalf("append after drained: %v", err)
+	}
+}
+
+// TestSpoolHandOff checks the spool opened twice, like on a zero-downtime restart, is taken
+// over by the second one only after the first is stopped.
+func TestSpoolHandOff(t *testing.T) {
+	dir := t.TempDir()
+	oldSink := &spoolSink{limit: 0}
+	newSink := &spoolSink{limit: -1}
+
+	old := openTestSpool(t, testSpoolConfig(), dir, oldSink)
+	old.Start()
+	keys := appendKeys(t, old, 0, 20)
+
+	s := openTestSpool(t, testSpoolConfig(), dir, newSink)
+	defer s.Stop()
+	s.Start()
+
+	if err := s.append([]byte("k"), []byte("value")); err != errSpoolLocked {
+		t.Fatalf("append = %v, want %v", err, errSpoolLocked)
+	}
+	if status := s.Status(); !strings.HasPrefix(status, "waiting") {
+		t.Fatalf("status = %q, want waiting", status)
+	}
+
+	// still appended by the old one, not truncated by the new one
+	keys = append(keys, appendKeys(t, old, 20, 25)...)
+	old.Stop()
+
+	waitSpool(t, func() bool { return len(newSink.drained()) == len(keys) })
+
+	if got := newSink.drained(); !reflect.DeepEqual(got, keys) {
+		t.Fatalf("drained = %v, want %v", got, keys)
+	}
+	if err := s.append([]byte("k"), []byte("value")); err != nil {
+		t.Fatalf("append after taken over: %v", err)
+	}
+}
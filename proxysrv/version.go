@@ -0,0 +1,51 @@
+package proxysrv
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// versionSeqBits is the bits of the sequence in a version, below the time in ms
+const versionSeqBits = 20
+
+// versionClock generates the last-write-wins versions, the unix time in ms followed by a
+// sequence. The versions are increasing within the proxy, and ordered by time across the
+// proxies.
+type versionClock struct {
+	last int64
+	seq  int64 // of the scheduled writes
+}
+
+// next returns the next version
+func (c *versionClock) next() int64 {
+	for {
+		last := atomic.LoadInt64(&c.last)
+		next := time.Now().UnixNano() / int64(time.Millisecond) << versionSeqBits
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&c.last, last, next) {
+			return next
+		}
+	}
+}
+
+// at returns a version of the time ms, the versions of the same ms are ordered by the call
+func (c *versionClock) at(ms int64) int64 {
+	seq := atomic.AddInt64(&c.seq, 1) & (1<<versionSeqBits - 1)
+	return ms<<versionSeqBits | seq
+}
+
+// stamp stamps the request with a version, unless supplied by client. A scheduled write is
+// versioned by its apply_at, so the writes of the key before it is due don't make it stale.
+func (s *proxyImpl) stamp(req *proxy.Request) {
+	switch {
+	case req.Version != 0:
+	case req.ApplyAtMs > 0:
+		req.Version = s.versions.at(req.ApplyAtMs)
+	default:
+		req.Version = s.versions.next()
+	}
+}
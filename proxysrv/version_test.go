@@ -0,0 +1,40 @@
+package proxysrv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stn81/nec/proto/proxy"
+)
+
+// TestStampScheduled checks a scheduled write is versioned by its apply_at, newer than the
+// writes before it is due and older than the ones after.
+func TestStampScheduled(t *testing.T) {
+	s := &proxyImpl{versions: &versionClock{}}
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+
+	scheduled := &proxy.Request{Cmd: "set", ApplyAtMs: nowMs + 60000}
+	s.stamp(scheduled)
+
+	before := &proxy.Request{Cmd: "set"}
+	s.stamp(before)
+	if before.Version >= scheduled.Version {
+		t.Fatalf("version of the write before due %v >= the scheduled %v", before.Version, scheduled.Version)
+	}
+
+	after := &proxy.Request{Cmd: "set", Version: (nowMs + 60001) << versionSeqBits}
+	if after.Version <= scheduled.Version {
+		t.Fatalf("version of the write after due %v <= the scheduled %v", after.Version, scheduled.Version)
+	}
+
+	same := &proxy.Request{Cmd: "set", ApplyAtMs: scheduled.ApplyAtMs}
+	s.stamp(same)
+	if same.Version <= scheduled.Version {
+		t.Fatalf("version of the later scheduled %v <= the earlier %v", same.Version, scheduled.Version)
+	}
+
+	supplied := &proxy.Request{Cmd: "set", ApplyAtMs: scheduled.ApplyAtMs, Version: 7}
+	if s.stamp(supplied); supplied.Version != 7 {
+		t.Fatalf("version supplied by client = %v, want 7", supplied.Version)
+	}
+}